@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+  "encoding/json"
+  "fmt"
+  "sync"
+)
+
+// A change to a commitment made by a transaction, as emitted by the chaincode
+type Transition struct {
+  TxID   string  `json:"txID"`
+  Spec   string  `json:"spec"`
+  ComID  string  `json:"comID"`
+  Event  string  `json:"event"`  // the event (e.g. Pay), operation (cancel, release, delegate, assign) or deadline transaction (expire, violate) applied
+  State  string  `json:"state"`  // lifecycle state of the commitment after the change
+}
+
+// Number of transitions buffered per subscriber before further ones are dropped for it
+const subscriberBuffer = 64
+
+// transitionFeed fans the transitions of committed transactions out to every subscriber
+type transitionFeed struct {
+  lock         sync.Mutex
+  subscribers  map[chan Transition]bool
+}
+
+// Subscribe returns a channel receiving every transition from now on, and a function to stop receiving them
+func (feed *transitionFeed) Subscribe() (<-chan Transition, func()) {
+  feed.lock.Lock()
+  defer feed.lock.Unlock()
+  if feed.subscribers == nil {
+    feed.subscribers = make(map[chan Transition]bool)
+  }
+  ch := make(chan Transition, subscriberBuffer)
+  feed.subscribers[ch] = true
+
+  var once sync.Once
+  return ch, func() {
+    once.Do(func() {
+      feed.lock.Lock()
+      defer feed.lock.Unlock()
+      delete(feed.subscribers, ch)
+      close(ch)
+    })
+  }
+}
+
+// Sends the transitions in an "eventInvoke" payload to every subscriber. A subscriber that
+// isn't keeping up misses transitions rather than holding up the others
+func (feed *transitionFeed) publish(txID string, payload []byte) {
+  var transitions []Transition
+  if err := json.Unmarshal(payload, &transitions); err != nil {
+    fmt.Printf("Ignoring chaincode event of transaction %s: %v\n", txID, err)
+    return
+  }
+
+  feed.lock.Lock()
+  defer feed.lock.Unlock()
+  for _, transition := range transitions {
+    transition.TxID = txID
+    for ch := range feed.subscribers {
+      select {
+        case ch <- transition:
+        default:
+      }
+    }
+  }
+}
@@ -1,79 +1,99 @@
 package blockchain
 
 import (
-  "fmt"
   "github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
-  "time"
 )
 
 // Initialise a new commitment spec on the blockchain
 func (setup *FabricSetup) InvokeInitSpec(specSource string) (string, error) {
+  return setup.invokeSpec("initSpec", specSource)
+}
 
-  // Prepare arguments
-  var args []string
-  args = append(args, "initSpec")
-  args = append(args, specSource)
+// Add data to blockchain. Like commitment operations, this waits for Execute's commit rather than an "eventInvoke"
+// event (see invokeCommitmentOperation)
+func (setup *FabricSetup) InvokeInitCommitmentData(jsonStrs []string) (string, error) {
 
-  eventID := "eventInvoke"
+  // Prepare arguments
+  argBytes := strArrToByteArr(jsonStrs)
 
   // Add data that will be visible in the proposal, like a description of the invoke request
   transientDataMap := make(map[string][]byte)
-  transientDataMap["result"] = []byte("Transient data in init spec invoke")
-
-  reg, notifier, err := setup.event.RegisterChaincodeEvent(setup.ChainCodeID, eventID)
-  if err != nil {
-    return "", err
-  }
-  defer setup.event.Unregister(reg)
+  transientDataMap["result"] = []byte("Transient data in init commitment data invoke")
 
   // Create a request (proposal) and send it
-  response, err := setup.client.Execute(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: [][]byte{[]byte(args[1])}, TransientMap: transientDataMap})
+  response, err := setup.client.Execute(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: "initCommitmentData", Args: argBytes, TransientMap: transientDataMap})
   if err != nil {
-    return "", fmt.Errorf("failed to move funds: %v", err)
+    return "", wrapError("failed to move funds", err)
   }
+  return string(response.TransactionID), nil
+}
 
-  // Wait for the result of the submission
-  select {
-    case ccEvent := <-notifier:
-      fmt.Printf("Received CC event: %v\n", ccEvent)
-    case <-time.After(time.Second * 20):
-      return "", fmt.Errorf("did NOT receive CC event for eventId(%s) in init spec", eventID)
-  }
+// Publish a new version of a registered commitment spec
+func (setup *FabricSetup) InvokeUpgradeSpec(specSource string) (string, error) {
+  return setup.invokeSpec("upgradeSpec", specSource)
+}
 
-  return string(response.TransactionID), nil
+// Cancel a commitment on behalf of its debtor
+func (setup *FabricSetup) InvokeCancelCommitment(comID string) (string, error) {
+  return setup.invokeCommitmentOperation("cancelCommitment", comID)
 }
 
-// Add data to blockchain
-func (setup *FabricSetup) InvokeInitCommitmentData(jsonStrs []string) (string, error) {
+// Release the debtor of a commitment on behalf of its creditor
+func (setup *FabricSetup) InvokeReleaseCommitment(comID string) (string, error) {
+  return setup.invokeCommitmentOperation("releaseCommitment", comID)
+}
 
-  // Prepare arguments
-  argBytes := strArrToByteArr(jsonStrs)
-  eventID := "eventInvoke"
+// Delegate a commitment to a new debtor
+func (setup *FabricSetup) InvokeDelegateCommitment(comID string, newDebtor string) (string, error) {
+  return setup.invokeCommitmentOperation("delegateCommitment", comID, newDebtor)
+}
+
+// Assign a commitment to a new creditor
+func (setup *FabricSetup) InvokeAssignCommitment(comID string, newCreditor string) (string, error) {
+  return setup.invokeCommitmentOperation("assignCommitment", comID, newCreditor)
+}
+
+// Record that a created commitment has expired, once its detach deadline has passed
+func (setup *FabricSetup) InvokeExpireCommitment(comID string) (string, error) {
+  return setup.invokeCommitmentOperation("expireCommitment", comID)
+}
+
+// Record that a detached commitment has been violated, once its discharge deadline has passed
+func (setup *FabricSetup) InvokeViolateCommitment(comID string) (string, error) {
+  return setup.invokeCommitmentOperation("violateCommitment", comID)
+}
+
+// Invoke a commitment operation (cancel, release, delegate, assign), deadline transaction (expire, violate) or spec
+// upgrade and wait for it to be committed. Execute only returns once the peers have committed the transaction, so
+// there is no "eventInvoke" event to wait for: operations that change nothing emit none, and the event client is
+// shared with every user of the organisation, whose events would be mistaken for this transaction's
+func (setup *FabricSetup) invokeCommitmentOperation(fcn string, args ...string) (string, error) {
 
   // Add data that will be visible in the proposal, like a description of the invoke request
   transientDataMap := make(map[string][]byte)
-  transientDataMap["result"] = []byte("Transient data in init commitment data invoke")
+  transientDataMap["result"] = []byte("Transient data in " + fcn + " invoke")
 
-  reg, notifier, err := setup.event.RegisterChaincodeEvent(setup.ChainCodeID, eventID)
+  // Create a request (proposal) and send it
+  response, err := setup.client.Execute(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: fcn, Args: strArrToByteArr(args), TransientMap: transientDataMap})
   if err != nil {
-    return "", err
+    return "", wrapError("failed to " + fcn, err)
   }
-  defer setup.event.Unregister(reg)
+  return string(response.TransactionID), nil
+}
+
+// Registers or upgrades a spec. These transactions don't change any commitment, so they emit no "eventInvoke"
+// event: Execute returns once the transaction is committed
+func (setup *FabricSetup) invokeSpec(fcn string, specSource string) (string, error) {
+
+  // Add data that will be visible in the proposal, like a description of the invoke request
+  transientDataMap := make(map[string][]byte)
+  transientDataMap["result"] = []byte("Transient data in " + fcn + " invoke")
 
   // Create a request (proposal) and send it
-  response, err := setup.client.Execute(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: "initCommitmentData", Args: argBytes, TransientMap: transientDataMap})
+  response, err := setup.client.Execute(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: fcn, Args: [][]byte{[]byte(specSource)}, TransientMap: transientDataMap})
   if err != nil {
-    return "", fmt.Errorf("failed to move funds: %v", err)
-  }
-
-  // Wait for the result of the submission
-  select {
-    case ccEvent := <-notifier:
-      fmt.Printf("Received CC event: %v\n", ccEvent)
-    case <-time.After(time.Second * 20):
-      return "", fmt.Errorf("did NOT receive CC event for eventId(%s) in init commitment data", eventID)
+    return "", wrapError("failed to " + fcn, err)
   }
-
   return string(response.TransactionID), nil
 }
 
@@ -0,0 +1,79 @@
+package blockchain
+
+import (
+  "fmt"
+  "time"
+
+  "github.com/hyperledger/fabric-sdk-go/pkg/common/errors/status"
+)
+
+// Ledger is the set of chaincode operations used by the web applications.
+// FabricSetup talks to a Fabric network, LocalLedger runs the chaincode in-process.
+type Ledger interface {
+  GetSpec(name string) (*Spec, error)
+  GetSpecVersions(name string) ([]Spec, error)
+  GetCommitments(comName string, comState string, asOf time.Time) ([]Commitment, error)
+  GetCommitmentsByAllStates(comName string, asOf time.Time) (map[string][]Commitment, error)
+  GetCommitmentsPage(comName string, comState string, asOf time.Time, pageSize int, bookmark string) (*CommitmentPage, error)
+  GetFilteredCommitmentsPage(comName string, comState string, filter CommitmentFilter, asOf time.Time, pageSize int, bookmark string) (*CommitmentPage, error)
+  GetCommitment(specName string, comID string) (*Commitment, error)
+  GetCommitmentStats(comName string, groupBy string, asOf time.Time) (*CommitmentStats, error)
+  GetCommitmentHistory(comID string) ([]HistoryEntry, error)
+  RichQuery(query string) (string, error)
+  RichQueryPage(query string, pageSize int, bookmark string) (*QueryPage, error)
+  InvokeInitSpec(specSource string) (string, error)
+  InvokeUpgradeSpec(specSource string) (string, error)
+  InvokeInitCommitmentData(jsonStrs []string) (string, error)
+  InvokeCancelCommitment(comID string) (string, error)
+  InvokeReleaseCommitment(comID string) (string, error)
+  InvokeDelegateCommitment(comID string, newDebtor string) (string, error)
+  InvokeAssignCommitment(comID string, newCreditor string) (string, error)
+  InvokeExpireCommitment(comID string) (string, error)
+  InvokeViolateCommitment(comID string) (string, error)
+  SubscribeTransitions() (<-chan Transition, func())
+  ForUser(userName string) (Ledger, error)
+}
+
+var _ Ledger = (*FabricSetup)(nil)
+var _ Ledger = (*LocalLedger)(nil)
+
+// Statuses the chaincode refuses requests with because of what was asked for (see ErrorStatus)
+const (
+  StatusForbidden = 403
+  StatusNotFound = 404
+)
+
+// A request the chaincode didn't accept, with the status of its response
+type ChaincodeError struct {
+  Status   int32
+  Message  string
+}
+
+func (err *ChaincodeError) Error() string {
+  return err.Message
+}
+
+// Status of a request the chaincode refused because of what was asked for (e.g. 403 when the
+// client isn't a party entitled to it, 404 when the spec or commitment doesn't exist), or 0
+// for any other error
+func ErrorStatus(err error) int32 {
+  code := int32(0)
+  if refused, ok := err.(*ChaincodeError); ok {
+    code = refused.Status
+  } else if s, ok := status.FromError(err); ok {
+    // Responses endorsed by a peer come back as SDK status errors, coded with the response status
+    code = s.Code
+  }
+  if code >= 400 && code < 500 {
+    return code
+  }
+  return 0
+}
+
+// Prefixes an error with what failed, keeping the status of a refused request (see ErrorStatus)
+func wrapError(what string, err error) error {
+  if code := ErrorStatus(err); code != 0 {
+    return &ChaincodeError{Status: code, Message: what + ": " + err.Error()}
+  }
+  return fmt.Errorf("%s: %v", what, err)
+}
@@ -0,0 +1,494 @@
+package blockchain
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/json"
+  "encoding/pem"
+  "fmt"
+  "math/big"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/golang/protobuf/proto"
+  "github.com/hyperledger/fabric/core/chaincode/shim"
+  "github.com/hyperledger/fabric/protos/ledger/queryresult"
+  "github.com/hyperledger/fabric/protos/msp"
+  "github.com/satori/go.uuid"
+  "github.com/scc300/scc300-network/chaincode/network"
+)
+
+// LocalLedger runs the chaincode in-process against a mock stub, so the applications
+// can be run and tested without a Fabric network. State is kept in memory only.
+type LocalLedger struct {
+  MSPID     string  // MSPID - the membership service provider transactions are submitted as
+  Domain    string  // Domain - the organisation domain in certificate names (e.g. User1@org1.hf.scc300.io)
+  UserName  string  // UserName - the user transactions are submitted as
+  creator   []byte
+  shared    *localNetwork
+}
+
+// The chaincode and state shared by every user of a local ledger
+type localNetwork struct {
+  cc           *network.SCC300NetworkChaincode
+  stub         *localStub
+  lock         sync.Mutex
+  transitions  transitionFeed
+  identities   map[string][]byte  // serialized identity of each user, guarded by lock
+}
+
+// NewLocalLedger creates an empty in-memory ledger and instantiates the chaincode on it
+func NewLocalLedger(mspID string, domain string, userName string) (*LocalLedger, error) {
+  cc := new(network.SCC300NetworkChaincode)
+  ledger := &LocalLedger{
+    MSPID: mspID,
+    Domain: domain,
+    shared: &localNetwork{
+      cc: cc,
+      stub: &localStub{
+        MockStub: shim.NewMockStub("scc300-network", cc),
+        history: make(map[string][]*queryresult.KeyModification),
+      },
+      identities: make(map[string][]byte),
+    },
+  }
+  user, err := ledger.ForUser(userName)
+  if err != nil {
+    return nil, err
+  }
+  ledger = user.(*LocalLedger)
+
+  // Instantiate the chaincode as the Fabric setup does
+  shared := ledger.shared
+  shared.lock.Lock()
+  defer shared.lock.Unlock()
+  txID := uuid.NewV4().String()
+  shared.stub.begin(txID, ledger.creator, []string{"init"})
+  res := cc.Init(shared.stub)
+  if res.Status != shim.OK {
+    shared.stub.MockTransactionEnd(txID)
+    return nil, fmt.Errorf("failed to instantiate chaincode: %s", res.Message)
+  }
+  shared.stub.commit()
+  shared.stub.MockTransactionEnd(txID)
+  return ledger, nil
+}
+
+// ForUser - the ledger as seen by a user of the organisation, whose transactions are signed
+// with their own identity (a self-signed certificate created on first use)
+func (ledger *LocalLedger) ForUser(userName string) (Ledger, error) {
+  shared := ledger.shared
+  shared.lock.Lock()
+  defer shared.lock.Unlock()
+  creator, ok := shared.identities[userName]
+  if !ok {
+    var err error
+    creator, err = newSerializedIdentity(ledger.MSPID, userName + "@" + ledger.Domain)
+    if err != nil {
+      return nil, fmt.Errorf("failed to create local identity of %s: %v", userName, err)
+    }
+    shared.identities[userName] = creator
+  }
+  return &LocalLedger{MSPID: ledger.MSPID, Domain: ledger.Domain, UserName: userName, creator: creator, shared: shared}, nil
+}
+
+// GetSpec - query the chaincode to get the state of a spec
+func (ledger *LocalLedger) GetSpec(name string) (*Spec, error) {
+  com := &Spec{}
+  payload, err := ledger.query("getSpec", name)
+  if err != nil {
+    return com, wrapError("failed to query", err)
+  }
+  json.Unmarshal(payload, &com)
+  return com, nil
+}
+
+// GetSpecVersions - query the chaincode to obtain every version of a spec, oldest first
+func (ledger *LocalLedger) GetSpecVersions(name string) ([]Spec, error) {
+  versions := []Spec{}
+  payload, err := ledger.query("getSpecVersions", name)
+  if err != nil {
+    return versions, wrapError("failed to query", err)
+  }
+  json.Unmarshal(payload, &versions)
+  return versions, nil
+}
+
+// GetCommitments - query the chaincode to obtain commitments for a particular state as of a date
+// (the time of the query if zero)
+func (ledger *LocalLedger) GetCommitments(comName string, comState string, asOf time.Time) ([]Commitment, error) {
+  commitments := []Commitment{}
+  args, err := commitmentsQueryArgs(comName, comState, asOf)
+  if err != nil {
+    return commitments, err
+  }
+  payload, err := ledger.query(args[0], args[1:]...)
+  if err != nil {
+    return commitments, wrapError("failed to query", err)
+  }
+  json.Unmarshal(payload, &commitments)
+  return commitments, nil
+}
+
+// GetCommitmentsByAllStates - query the chaincode to obtain every commitment of a spec grouped by its current state
+// (see CurrentStates) as of a date (the time of the query if zero)
+func (ledger *LocalLedger) GetCommitmentsByAllStates(comName string, asOf time.Time) (map[string][]Commitment, error) {
+  byState := map[string][]Commitment{}
+  args := commitmentsByAllStatesArgs(comName, asOf)
+  payload, err := ledger.query(args[0], args[1:]...)
+  if err != nil {
+    return byState, wrapError("failed to query", err)
+  }
+  json.Unmarshal(payload, &byState)
+  return byState, nil
+}
+
+// GetCommitmentsPage - query the chaincode to obtain a page of the commitments in a particular state as of a
+// date (the time of the query if zero), starting after the bookmark ("" for the first page)
+func (ledger *LocalLedger) GetCommitmentsPage(comName string, comState string, asOf time.Time, pageSize int, bookmark string) (*CommitmentPage, error) {
+  page := &CommitmentPage{Commitments: []Commitment{}}
+  args := commitmentsPageArgs(comName, comState, asOf, pageSize, bookmark)
+  payload, err := ledger.query(args[0], args[1:]...)
+  if err != nil {
+    return page, wrapError("failed to query", err)
+  }
+  json.Unmarshal(payload, page)
+  return page, nil
+}
+
+// GetFilteredCommitmentsPage - query the chaincode to obtain a page of the commitments in a particular state ("" for
+// any) matching a filter as of a date (the time of the query if zero), starting after the bookmark ("" for the first page)
+func (ledger *LocalLedger) GetFilteredCommitmentsPage(comName string, comState string, filter CommitmentFilter, asOf time.Time, pageSize int, bookmark string) (*CommitmentPage, error) {
+  page := &CommitmentPage{Commitments: []Commitment{}}
+  args, err := filteredCommitmentsPageArgs(comName, comState, filter, asOf, pageSize, bookmark)
+  if err != nil {
+    return page, err
+  }
+  payload, err := ledger.query(args[0], args[1:]...)
+  if err != nil {
+    return page, wrapError("failed to query", err)
+  }
+  json.Unmarshal(payload, page)
+  return page, nil
+}
+
+// GetCommitmentStats - query the chaincode to obtain the stats of the commitments of a spec as of a date (the time of
+// the query if zero), also grouped by an event argument unless groupBy is ""
+func (ledger *LocalLedger) GetCommitmentStats(comName string, groupBy string, asOf time.Time) (*CommitmentStats, error) {
+  args := commitmentStatsArgs(comName, groupBy, asOf)
+  payload, err := ledger.query(args[0], args[1:]...)
+  if err != nil {
+    return nil, wrapError("failed to query", err)
+  }
+  stats := &CommitmentStats{}
+  json.Unmarshal(payload, stats)
+  return stats, nil
+}
+
+// GetCommitment - query the chaincode to obtain a single commitment with its full lifecycle
+// (specName may be "" to find the commitment whatever its spec)
+func (ledger *LocalLedger) GetCommitment(specName string, comID string) (*Commitment, error) {
+  payload, err := ledger.query("getCommitment", specName, comID)
+  if err != nil {
+    return nil, wrapError("failed to query", err)
+  }
+  com := &Commitment{}
+  json.Unmarshal(payload, com)
+  return com, nil
+}
+
+// GetCommitmentHistory - query the chaincode to obtain every write made to a commitment, oldest first
+func (ledger *LocalLedger) GetCommitmentHistory(comID string) ([]HistoryEntry, error) {
+  history := []HistoryEntry{}
+  payload, err := ledger.query("getCommitmentHistory", comID)
+  if err != nil {
+    return history, wrapError("failed to query history", err)
+  }
+  json.Unmarshal(payload, &history)
+  return history, nil
+}
+
+// RichQuery - query the chaincode to perform an ad hoc rich query based on input
+func (ledger *LocalLedger) RichQuery(query string) (string, error) {
+  payload, err := ledger.query("richQuery", query)
+  if err != nil {
+    return "", wrapError("failed to perform rich query", err)
+  }
+  return string(payload), nil
+}
+
+// RichQueryPage - query the chaincode to perform an ad hoc rich query a page at a time in key order, starting after the
+// bookmark ("" for the first page)
+func (ledger *LocalLedger) RichQueryPage(query string, pageSize int, bookmark string) (*QueryPage, error) {
+  page := &QueryPage{}
+  payload, err := ledger.query("richQueryWithPagination", query, strconv.Itoa(pageSize), bookmark)
+  if err != nil {
+    return page, wrapError("failed to perform rich query", err)
+  }
+  json.Unmarshal(payload, page)
+  return page, nil
+}
+
+// Initialise a new commitment spec
+func (ledger *LocalLedger) InvokeInitSpec(specSource string) (string, error) {
+  return ledger.invoke("initSpec", specSource)
+}
+
+// Publish a new version of a registered commitment spec
+func (ledger *LocalLedger) InvokeUpgradeSpec(specSource string) (string, error) {
+  return ledger.invoke("upgradeSpec", specSource)
+}
+
+// Add commitment data
+func (ledger *LocalLedger) InvokeInitCommitmentData(jsonStrs []string) (string, error) {
+  return ledger.invoke("initCommitmentData", jsonStrs...)
+}
+
+// Cancel a commitment on behalf of its debtor
+func (ledger *LocalLedger) InvokeCancelCommitment(comID string) (string, error) {
+  return ledger.invoke("cancelCommitment", comID)
+}
+
+// Release the debtor of a commitment on behalf of its creditor
+func (ledger *LocalLedger) InvokeReleaseCommitment(comID string) (string, error) {
+  return ledger.invoke("releaseCommitment", comID)
+}
+
+// Delegate a commitment to a new debtor
+func (ledger *LocalLedger) InvokeDelegateCommitment(comID string, newDebtor string) (string, error) {
+  return ledger.invoke("delegateCommitment", comID, newDebtor)
+}
+
+// Assign a commitment to a new creditor
+func (ledger *LocalLedger) InvokeAssignCommitment(comID string, newCreditor string) (string, error) {
+  return ledger.invoke("assignCommitment", comID, newCreditor)
+}
+
+// Record that a created commitment has expired, once its detach deadline has passed
+func (ledger *LocalLedger) InvokeExpireCommitment(comID string) (string, error) {
+  return ledger.invoke("expireCommitment", comID)
+}
+
+// Record that a detached commitment has been violated, once its discharge deadline has passed
+func (ledger *LocalLedger) InvokeViolateCommitment(comID string) (string, error) {
+  return ledger.invoke("violateCommitment", comID)
+}
+
+// SubscribeTransitions - receive the commitment transitions of every committed transaction
+func (ledger *LocalLedger) SubscribeTransitions() (<-chan Transition, func()) {
+  return ledger.shared.transitions.Subscribe()
+}
+
+// Runs a read-only chaincode function. Any writes it makes are discarded, as a query isn't ordered
+func (ledger *LocalLedger) query(fcn string, args ...string) ([]byte, error) {
+  payload, _, err := ledger.execute(false, fcn, args...)
+  return payload, err
+}
+
+// Runs a chaincode function as a transaction, committing its writes if it succeeds
+func (ledger *LocalLedger) invoke(fcn string, args ...string) (string, error) {
+  _, txID, err := ledger.execute(true, fcn, args...)
+  if err != nil {
+    return "", wrapError("failed to " + fcn, err)
+  }
+  return txID, nil
+}
+
+// Transactions run one at a time, so each sees the state committed by the previous one
+func (ledger *LocalLedger) execute(commit bool, fcn string, args ...string) ([]byte, string, error) {
+  shared := ledger.shared
+  shared.lock.Lock()
+  defer shared.lock.Unlock()
+
+  txID := uuid.NewV4().String()
+  shared.stub.begin(txID, ledger.creator, append([]string{fcn}, args...))
+  defer shared.stub.MockTransactionEnd(txID)
+
+  res := shared.cc.Invoke(shared.stub)
+  if res.Status != shim.OK {
+    return nil, txID, &ChaincodeError{Status: res.Status, Message: res.Message}
+  }
+  if commit {
+    shared.stub.commit()
+    if shared.stub.event != nil {
+      shared.transitions.publish(txID, shared.stub.event)
+    }
+  }
+  return res.Payload, txID, nil
+}
+
+// A pending write of a transaction
+type localWrite struct {
+  key       string
+  value     []byte
+  isDelete  bool
+}
+
+// localStub extends the shim's MockStub with what the chaincode needs from a peer: CouchDB rich
+// queries, key history, a client identity, and writes that only become visible once the
+// transaction succeeds.
+type localStub struct {
+  *shim.MockStub
+  args     [][]byte
+  creator  []byte
+  writes   []localWrite
+  event    []byte
+  history  map[string][]*queryresult.KeyModification
+}
+
+// Starts a transaction submitted by the given client with the given function and arguments
+func (stub *localStub) begin(txID string, creator []byte, args []string) {
+  stub.creator = creator
+  stub.args = make([][]byte, len(args))
+  for i, arg := range args {
+    stub.args[i] = []byte(arg)
+  }
+  stub.writes = nil
+  stub.event = nil
+  stub.MockTransactionStart(txID)
+}
+
+// Applies the pending writes of the transaction to state, recording them in the key history
+func (stub *localStub) commit() {
+  for _, write := range stub.writes {
+    if write.isDelete {
+      stub.MockStub.DelState(write.key)
+    } else {
+      stub.MockStub.PutState(write.key, write.value)
+    }
+    stub.history[write.key] = append(stub.history[write.key], &queryresult.KeyModification{
+      TxId: stub.TxID,
+      Value: write.value,
+      Timestamp: stub.TxTimestamp,
+      IsDelete: write.isDelete,
+    })
+  }
+  stub.writes = nil
+}
+
+func (stub *localStub) GetArgs() [][]byte {
+  return stub.args
+}
+
+func (stub *localStub) GetStringArgs() []string {
+  strs := make([]string, len(stub.args))
+  for i, arg := range stub.args {
+    strs[i] = string(arg)
+  }
+  return strs
+}
+
+func (stub *localStub) GetFunctionAndParameters() (string, []string) {
+  strs := stub.GetStringArgs()
+  if len(strs) == 0 {
+    return "", []string{}
+  }
+  return strs[0], strs[1:]
+}
+
+func (stub *localStub) GetCreator() ([]byte, error) {
+  return stub.creator, nil
+}
+
+// As on a peer, a transaction has at most one event, published once it's committed
+func (stub *localStub) SetEvent(name string, payload []byte) error {
+  if name == "" {
+    return fmt.Errorf("event name can not be nil string")
+  }
+  stub.event = payload
+  return nil
+}
+
+// As on a peer, writes aren't readable until the transaction is committed
+func (stub *localStub) PutState(key string, value []byte) error {
+  if key == "" {
+    return fmt.Errorf("key must not be an empty string")
+  }
+  stub.writes = append(stub.writes, localWrite{key: key, value: value})
+  return nil
+}
+
+func (stub *localStub) DelState(key string) error {
+  stub.writes = append(stub.writes, localWrite{key: key, isDelete: true})
+  return nil
+}
+
+// Evaluates a CouchDB query against the committed state
+func (stub *localStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+  kvs := []*queryresult.KV{}
+  for e := stub.Keys.Front(); e != nil; e = e.Next() {
+    key := e.Value.(string)
+    kvs = append(kvs, &queryresult.KV{Key: key, Value: stub.State[key]})
+  }
+  results, err := runCouchQuery(kvs, query)
+  if err != nil {
+    return nil, err
+  }
+  return &localStateIterator{results: results}, nil
+}
+
+func (stub *localStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+  return &localHistoryIterator{results: stub.history[key]}, nil
+}
+
+// Iterates over the results of a rich query
+type localStateIterator struct {
+  results  []*queryresult.KV
+  next     int
+}
+
+func (it *localStateIterator) HasNext() bool { return it.next < len(it.results) }
+
+func (it *localStateIterator) Next() (*queryresult.KV, error) {
+  if !it.HasNext() {
+    return nil, fmt.Errorf("no more results")
+  }
+  it.next++
+  return it.results[it.next - 1], nil
+}
+
+func (it *localStateIterator) Close() error { return nil }
+
+// Iterates over the history of a key
+type localHistoryIterator struct {
+  results  []*queryresult.KeyModification
+  next     int
+}
+
+func (it *localHistoryIterator) HasNext() bool { return it.next < len(it.results) }
+
+func (it *localHistoryIterator) Next() (*queryresult.KeyModification, error) {
+  if !it.HasNext() {
+    return nil, fmt.Errorf("no more results")
+  }
+  it.next++
+  return it.results[it.next - 1], nil
+}
+
+func (it *localHistoryIterator) Close() error { return nil }
+
+// Creates the serialized identity of a client with a self-signed certificate, as read by the
+// chaincode's client identity library (cid)
+func newSerializedIdentity(mspID string, userName string) ([]byte, error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return nil, err
+  }
+  template := x509.Certificate{
+    SerialNumber: big.NewInt(time.Now().UnixNano()),
+    Subject: pkix.Name{CommonName: userName, Organization: []string{mspID}},
+    NotBefore: time.Now().Add(-time.Hour),
+    NotAfter: time.Now().AddDate(10, 0, 0),
+    KeyUsage: x509.KeyUsageDigitalSignature,
+  }
+  der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+  if err != nil {
+    return nil, err
+  }
+  cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+  return proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: cert})
+}
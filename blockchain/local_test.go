@@ -0,0 +1,494 @@
+package blockchain
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+  "time"
+)
+
+// A merchant (the debtor of every commitment) and a customer (the creditor) sharing a local ledger
+func newTestLedgers(t *testing.T) (Ledger, Ledger) {
+  merchant, err := NewLocalLedger("Org1MSP", "org1.hf.scc300.io", "User1")
+  if err != nil {
+    t.Fatal(err)
+  }
+  customer, err := merchant.ForUser("User2")
+  if err != nil {
+    t.Fatal(err)
+  }
+  specs := []string{
+    "spec SellItem dID to cID\n  create Offer [item,price:decimal]\n  detach Pay [amount:decimal] where sum(Pay.amount) >= Offer.price deadline=1w\n  discharge Delivery [courier] deadline=1w",
+    // Deadlines that have passed as soon as they are set, to expire and violate commitments
+    "spec FlashSale dID to cID\n  create Offer [item]\n  detach Pay [amount] deadline=0m\n  discharge Delivery [courier] deadline=1w",
+    "spec SameDay dID to cID\n  create Offer [item]\n  detach Pay [amount] deadline=1w\n  discharge Delivery [courier] deadline=0m",
+  }
+  for _, spec := range specs {
+    if _, err := merchant.InvokeInitSpec(spec); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return merchant, customer
+}
+
+// Submits an event, failing the test if it isn't accepted
+func submit(t *testing.T, ledger Ledger, event string) {
+  if _, err := ledger.InvokeInitCommitmentData([]string{event}); err != nil {
+    t.Fatalf("%s: %v", event, err)
+  }
+}
+
+// Checks the state of a commitment
+func expectState(t *testing.T, ledger Ledger, specName string, comID string, state string) {
+  com, err := ledger.GetCommitment(specName, comID)
+  if err != nil {
+    t.Fatalf("commitment %s: %v", comID, err)
+  }
+  if com.State != state {
+    t.Errorf("commitment %s is %s, expected %s", comID, com.State, state)
+  }
+}
+
+// Checks the IDs of the commitments of a spec listed in a state as of a date (now if zero)
+func expectCommitments(t *testing.T, ledger Ledger, specName string, state string, asOf time.Time, comIDs ...string) {
+  coms, err := ledger.GetCommitments(specName, state, asOf)
+  if err != nil {
+    t.Fatalf("%s commitments: %v", state, err)
+  }
+  listed := map[string]bool{}
+  for _, com := range coms {
+    listed[com.ComID] = true
+  }
+  if len(coms) != len(comIDs) {
+    t.Errorf("%d %s %s commitments as of %v, expected %v", len(coms), specName, state, asOf, comIDs)
+  }
+  for _, comID := range comIDs {
+    if !listed[comID] {
+      t.Errorf("commitment %s isn't %s as of %v", comID, state, asOf)
+    }
+  }
+}
+
+func TestLocalLedgerLifecycle(t *testing.T) {
+  merchant, customer := newTestLedgers(t)
+
+  // ==== Created, detached once paid in full and discharged on delivery, each within its deadline ==== //
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"sale","item":"chair","price":"30","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  expectState(t, merchant, "SellItem", "sale", "created")
+  submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"10"}`)
+  expectState(t, merchant, "SellItem", "sale", "created")
+  submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"20"}`)
+  expectState(t, merchant, "SellItem", "sale", "detached")
+  submit(t, merchant, `{"docType":"Delivery","spec":"SellItem","comID":"sale","courier":"DHL"}`)
+  expectState(t, merchant, "SellItem", "sale", "discharged")
+
+  com, err := customer.GetCommitment("SellItem", "sale")
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, state := range []string{"created", "detached", "discharged"} {
+    if com.Transitions[state] == "" {
+      t.Errorf("no %s transition in %v", state, com.Transitions)
+    }
+  }
+  if _, err := merchant.GetCommitment("SellItem", "missing"); ErrorStatus(err) != StatusNotFound {
+    t.Errorf("getting a missing commitment: %v, expected status %d", err, StatusNotFound)
+  }
+
+  // ==== Created and left unpaid ==== //
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"open","item":"sofa","price":"300","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+
+  // ==== Expired and violated as soon as their deadlines are set ==== //
+  submit(t, merchant, `{"docType":"Offer","spec":"FlashSale","comID":"flash","item":"lamp","debtor":"Shop","creditor":"Sally","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, merchant, `{"docType":"Offer","spec":"SameDay","comID":"rush","item":"desk","debtor":"Shop","creditor":"Sally","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, customer, `{"docType":"Pay","spec":"SameDay","comID":"rush","amount":"50"}`)
+
+  // ==== Expiry and violation are recorded on the ledger once, and only after the deadline ==== //
+  expectState(t, merchant, "FlashSale", "flash", "expired")
+  if _, err := merchant.InvokeExpireCommitment("flash"); err != nil {
+    t.Fatalf("expire: %v", err)
+  }
+  expectState(t, merchant, "FlashSale", "flash", "expired")
+  expectState(t, merchant, "SameDay", "rush", "violated")
+  if _, err := merchant.InvokeViolateCommitment("rush"); err != nil {
+    t.Fatalf("violate: %v", err)
+  }
+  expectState(t, merchant, "SameDay", "rush", "violated")
+  if _, err := merchant.InvokeExpireCommitment("open"); err == nil {
+    t.Error("expired a commitment before its deadline")
+  }
+  if _, err := merchant.InvokeViolateCommitment("open"); err == nil {
+    t.Error("violated a commitment that isn't detached")
+  }
+  if _, err := merchant.InvokeExpireCommitment("missing"); ErrorStatus(err) != StatusNotFound {
+    t.Errorf("expiring a missing commitment: %v, expected status %d", err, StatusNotFound)
+  }
+
+  // ==== Cancelled by its debtor before payment ==== //
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"cancel","item":"desk","price":"80","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  if _, err := merchant.InvokeCancelCommitment("cancel"); err != nil {
+    t.Fatalf("cancel: %v", err)
+  }
+  if _, err := customer.InvokeReleaseCommitment("cancel"); err == nil {
+    t.Error("released a cancelled commitment")
+  }
+
+  now := time.Time{}
+  later := time.Now().AddDate(0, 0, 8)
+  expectCommitments(t, merchant, "SellItem", "created", now, "sale", "open", "cancel")
+  expectCommitments(t, merchant, "SellItem", "detached", now, "sale")
+  expectCommitments(t, merchant, "SellItem", "discharged", now, "sale")
+  expectCommitments(t, merchant, "SellItem", "cancelled", now, "cancel")
+  expectCommitments(t, merchant, "SellItem", "expired", now)
+  expectCommitments(t, merchant, "SellItem", "expired", later, "open")
+  expectCommitments(t, merchant, "FlashSale", "expired", now, "flash")
+  expectCommitments(t, merchant, "SameDay", "violated", now, "rush")
+
+  // ==== Classified by current state in one call ==== //
+  for _, asOf := range []time.Time{now, later} {
+    byState, err := merchant.GetCommitmentsByAllStates("SellItem", asOf)
+    if err != nil {
+      t.Fatal(err)
+    }
+    counts := map[string]int{}
+    for state, coms := range byState {
+      counts[state] = len(coms)
+    }
+    expected := map[string]int{"conditional": 1, "discharged": 1, "cancelled": 1}
+    if asOf == later {
+      expected = map[string]int{"expired": 1, "discharged": 1, "cancelled": 1}
+    }
+    for _, state := range CurrentStates {
+      if counts[state] != expected[state] {
+        t.Errorf("%d %s commitments as of %v, expected %d", counts[state], state, asOf, expected[state])
+      }
+    }
+  }
+
+  history, err := merchant.GetCommitmentHistory("sale")
+  if err != nil {
+    t.Fatal(err)
+  }
+  // The payments and the record writes they made were submitted by the customer
+  submitters := map[string]int{}
+  for _, entry := range history {
+    submitters[entry.MSPID + " " + entry.Submitter]++
+  }
+  if submitters["Org1MSP User1@org1.hf.scc300.io"] != 4 || submitters["Org1MSP User2@org1.hf.scc300.io"] != 4 {
+    t.Errorf("history submitted by %v", submitters)
+  }
+}
+
+func TestLocalLedgerRejectsInvalidEvents(t *testing.T) {
+  merchant, customer := newTestLedgers(t)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"sale","item":"chair","price":"30","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"30"}`)
+
+  // ==== Every rejected event is reported, and none of the batch is written ==== //
+  _, err := merchant.InvokeInitCommitmentData([]string{
+    `{"docType":"Offer","spec":"SellItem","comID":"new","item":"lamp","price":"5","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`,
+    `{"docType":"Offer","spec":"SellItem","comID":"c1","item":"chair","price":"thirty","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`,
+    `{"docType":"Refund","spec":"SellItem","comID":"sale","amount":"30"}`,
+    `{"docType":"Delivery","spec":"SellItem","comID":"missing","courier":"DHL"}`,
+    `not JSON`,
+  })
+  if err == nil {
+    t.Fatal("accepted a batch of invalid events")
+  }
+  for _, expected := range []string{"Rejected 4 of 5 events", "event 2", "event 3", "event 4", "event 5"} {
+    if !strings.Contains(err.Error(), expected) {
+      t.Errorf("%v, expected it to contain %q", err, expected)
+    }
+  }
+  expectCommitments(t, merchant, "SellItem", "created", time.Time{}, "sale")
+
+  // ==== Dates are set by the chaincode, and debtors and creditors only by create events ==== //
+  if _, err := customer.InvokeInitCommitmentData([]string{`{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"30","date":"Fri Jan  5 10:00:00 2018"}`}); err == nil {
+    t.Error("accepted a client-supplied date")
+  }
+  if _, err := customer.InvokeInitCommitmentData([]string{`{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"30","creditor":"Sally"}`}); err == nil {
+    t.Error("accepted a creditor on a detach event")
+  }
+
+  // ==== Events shared by several specs must name their spec, which must be the commitment's ==== //
+  if _, err := merchant.InvokeInitCommitmentData([]string{`{"docType":"Offer","comID":"anon","item":"chair","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`}); err == nil || !strings.Contains(err.Error(), "the spec must be given") {
+    t.Errorf("an offer without its spec: %v", err)
+  }
+  if _, err := merchant.InvokeInitCommitmentData([]string{`{"docType":"Delivery","spec":"SameDay","comID":"sale","courier":"DHL"}`}); err == nil {
+    t.Error("accepted an event of another spec for a commitment")
+  }
+
+  // ==== Commitment IDs are only unique within their spec ==== //
+  submit(t, merchant, `{"docType":"Offer","spec":"SameDay","comID":"sale","item":"desk","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  expectState(t, merchant, "SellItem", "sale", "detached")
+  expectState(t, merchant, "SameDay", "sale", "created")
+  if _, err := merchant.InvokeCancelCommitment("sale"); err == nil || !strings.Contains(err.Error(), "the spec must be given") {
+    t.Errorf("cancelling a commitment ID of two specs: %v", err)
+  }
+
+  if _, err := merchant.InvokeCancelCommitment("missing"); err == nil {
+    t.Error("cancelled a commitment that doesn't exist")
+  }
+}
+
+func TestLocalLedgerAuthorization(t *testing.T) {
+  merchant, customer := newTestLedgers(t)
+  other, err := merchant.ForUser("User3")
+  if err != nil {
+    t.Fatal(err)
+  }
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"sale","item":"chair","price":"30","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+
+  refused := []struct {
+    name    string
+    invoke  func() (string, error)
+  }{
+    {"a create event from neither party", func() (string, error) {
+      return other.InvokeInitCommitmentData([]string{`{"docType":"Offer","spec":"SellItem","comID":"fake","item":"chair","price":"1","debtor":"Shop","creditor":"Harry","debtorID":"User1@org1.hf.scc300.io","creditorID":"User2@org1.hf.scc300.io"}`})
+    }},
+    {"a create event from the creditor binding another debtor", func() (string, error) {
+      return customer.InvokeInitCommitmentData([]string{`{"docType":"Offer","spec":"SellItem","comID":"owed","item":"chair","price":"1","debtor":"Shop","creditor":"Harry","debtorID":"User1@org1.hf.scc300.io","creditorID":"User2@org1.hf.scc300.io"}`})
+    }},
+    {"a detach event from the debtor", func() (string, error) {
+      return merchant.InvokeInitCommitmentData([]string{`{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"30"}`})
+    }},
+    {"a discharge event from the creditor", func() (string, error) {
+      return customer.InvokeInitCommitmentData([]string{`{"docType":"Delivery","spec":"SellItem","comID":"sale","courier":"DHL"}`})
+    }},
+    {"a cancel by the creditor", func() (string, error) {
+      return customer.InvokeCancelCommitment("sale")
+    }},
+    {"a release by the debtor", func() (string, error) {
+      return merchant.InvokeReleaseCommitment("sale")
+    }},
+    {"an assignment by another client", func() (string, error) {
+      return other.InvokeAssignCommitment("sale", "User3@org1.hf.scc300.io")
+    }},
+  }
+  for _, test := range refused {
+    if _, err := test.invoke(); ErrorStatus(err) != StatusForbidden {
+      t.Errorf("%s: %v, expected status %d", test.name, err, StatusForbidden)
+    }
+  }
+
+  // ==== Only the parties of a commitment read it, by ID or in lists ==== //
+  if _, err := other.GetCommitment("SellItem", "sale"); ErrorStatus(err) != StatusForbidden {
+    t.Errorf("another client reading the commitment: %v, expected status %d", err, StatusForbidden)
+  }
+  if _, err := other.GetCommitmentHistory("sale"); ErrorStatus(err) != StatusForbidden {
+    t.Errorf("another client reading the history: %v, expected status %d", err, StatusForbidden)
+  }
+  expectCommitments(t, customer, "SellItem", "created", time.Time{}, "sale")
+  expectCommitments(t, other, "SellItem", "created", time.Time{})
+  if stats, err := other.GetCommitmentStats("SellItem", "", time.Time{}); err != nil || stats.Total != 0 {
+    t.Errorf("another client's stats: %+v, %v, expected no commitments", stats, err)
+  }
+
+  // ==== Once delegated, the commitment is the new debtor's alone ==== //
+  if _, err := merchant.InvokeDelegateCommitment("sale", "User3@org1.hf.scc300.io"); err != nil {
+    t.Fatalf("delegate: %v", err)
+  }
+  if _, err := merchant.InvokeCancelCommitment("sale"); err == nil {
+    t.Error("the old debtor cancelled a delegated commitment")
+  }
+  if _, err := other.InvokeCancelCommitment("sale"); err != nil {
+    t.Errorf("the new debtor couldn't cancel: %v", err)
+  }
+
+  // ==== Assigned to a client identity, which the creditor role is bound to ==== //
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"gift","item":"lamp","price":"5","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  if _, err := customer.InvokeAssignCommitment("gift", "Org1MSP:"); err == nil {
+    t.Error("assigned a commitment to an invalid identity")
+  }
+  if _, err := customer.InvokeAssignCommitment("gift", "Org1MSP:User3@org1.hf.scc300.io"); err != nil {
+    t.Fatalf("assign: %v", err)
+  }
+  com, err := merchant.GetCommitment("SellItem", "gift")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if com.CreditorID == nil || com.CreditorID.MSPID != "Org1MSP" || com.CreditorID.Name != "User3@org1.hf.scc300.io" {
+    t.Errorf("creditor bound to %+v after assigning", com.CreditorID)
+  }
+  if _, err := customer.InvokeReleaseCommitment("gift"); ErrorStatus(err) != StatusForbidden {
+    t.Errorf("the old creditor releasing an assigned commitment: %v, expected status %d", err, StatusForbidden)
+  }
+  if _, err := other.InvokeReleaseCommitment("gift"); err != nil {
+    t.Errorf("the new creditor couldn't release: %v", err)
+  }
+}
+
+func TestLocalLedgerPages(t *testing.T) {
+  merchant, customer := newTestLedgers(t)
+  for _, comID := range []string{"a", "b", "c", "d", "e"} {
+    submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"` + comID + `","item":"chair","price":"30","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  }
+  for _, comID := range []string{"b", "d"} {
+    submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"` + comID + `","amount":"30"}`)
+  }
+
+  tests := []struct {
+    state  string
+    pages  [][]string  // commitment IDs expected on each page
+  }{
+    {"created", [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+    {"conditional", [][]string{{"a", "c"}, {"e"}}},
+    // The last page is full, with no bookmark to an empty page after it
+    {"active", [][]string{{"b", "d"}}},
+    {"violated", [][]string{{}}},
+  }
+  for _, test := range tests {
+    bookmark := ""
+    for i, expected := range test.pages {
+      page, err := merchant.GetCommitmentsPage("SellItem", test.state, time.Time{}, 2, bookmark)
+      if err != nil {
+        t.Fatalf("%s page %d: %v", test.state, i + 1, err)
+      }
+      ids := []string{}
+      for _, com := range page.Commitments {
+        ids = append(ids, com.ComID)
+      }
+      if len(ids) != len(expected) || (len(ids) > 0 && ids[0] != expected[0]) || (len(ids) > 1 && ids[1] != expected[1]) {
+        t.Errorf("%s page %d = %v, expected %v", test.state, i + 1, ids, expected)
+      }
+      bookmark = page.Bookmark
+      if last := i == len(test.pages) - 1; last != (bookmark == "") {
+        t.Fatalf("%s page %d has bookmark %q", test.state, i + 1, bookmark)
+      }
+    }
+  }
+
+  if _, err := merchant.GetCommitmentsPage("SellItem", "created", time.Time{}, 2, "not base64!"); err == nil {
+    t.Error("accepted an invalid bookmark")
+  }
+}
+
+func TestLocalLedgerFilters(t *testing.T) {
+  merchant, customer := newTestLedgers(t)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"chair","item":"Chair","price":"30","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"lamp","item":"Lamp","price":"12","debtor":"Shop","creditor":"Sally","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"sofa","item":"Sofa","price":"300","debtor":"Outlet","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"sofa","amount":"50"}`)
+
+  tests := []struct {
+    state   string
+    filter  CommitmentFilter
+    comIDs  string
+  }{
+    {"", CommitmentFilter{}, "chair,lamp,sofa"},
+    {"", CommitmentFilter{Creditor: "Harry"}, "chair,sofa"},
+    {"", CommitmentFilter{Debtor: "Shop", Creditor: "Harry"}, "chair"},
+    {"", CommitmentFilter{Args: []string{"price>20"}}, "chair,sofa"},
+    {"", CommitmentFilter{Args: []string{"item=Lamp"}}, "lamp"},
+    {"", CommitmentFilter{Args: []string{"Pay.amount>=10"}}, "sofa"},
+    {"", CommitmentFilter{Args: []string{"price>20", "item!=Sofa"}}, "chair"},
+    {"created", CommitmentFilter{Creditor: "Sally"}, "lamp"},
+    {"", CommitmentFilter{CreatedAfter: time.Now().Add(time.Hour)}, ""},
+    {"", CommitmentFilter{CreatedBefore: time.Now().Add(time.Hour)}, "chair,lamp,sofa"},
+  }
+  for _, test := range tests {
+    page, err := merchant.GetFilteredCommitmentsPage("SellItem", test.state, test.filter, time.Time{}, 10, "")
+    if err != nil {
+      t.Errorf("%+v: %v", test.filter, err)
+      continue
+    }
+    ids := []string{}
+    for _, com := range page.Commitments {
+      ids = append(ids, com.ComID)
+    }
+    if strings.Join(ids, ",") != test.comIDs {
+      t.Errorf("%s commitments matching %+v = %v, expected %s", test.state, test.filter, ids, test.comIDs)
+    }
+  }
+
+  if _, err := merchant.GetFilteredCommitmentsPage("SellItem", "", CommitmentFilter{Args: []string{"price~20"}}, time.Time{}, 10, ""); err == nil {
+    t.Error("accepted an argument predicate without an operator")
+  }
+}
+
+func TestLocalLedgerFilteredPagesAreCapped(t *testing.T) {
+  merchant, _ := newTestLedgers(t)
+  // ==== Only the last of a dozen commitments matches, and the selector can't tell which ==== //
+  for i := 0; i < 12; i++ {
+    price := "5"
+    if i == 11 {
+      price = "50"
+    }
+    submit(t, merchant, fmt.Sprintf(`{"docType":"Offer","spec":"SellItem","comID":"c%02d","item":"Chair","price":"%s","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`, i, price))
+  }
+
+  filter := CommitmentFilter{Args: []string{"price>20"}}
+  page, err := merchant.GetFilteredCommitmentsPage("SellItem", "", filter, time.Time{}, 1, "")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(page.Commitments) != 0 || page.Bookmark == "" {
+    t.Fatalf("first page = %d commitments with bookmark %q, expected it empty with a bookmark", len(page.Commitments), page.Bookmark)
+  }
+  ids := []string{}
+  for pages := 1; page.Bookmark != ""; pages++ {
+    if pages > 3 {
+      t.Fatal("still following bookmarks after 3 pages")
+    }
+    if page, err = merchant.GetFilteredCommitmentsPage("SellItem", "", filter, time.Time{}, 1, page.Bookmark); err != nil {
+      t.Fatal(err)
+    }
+    for _, com := range page.Commitments {
+      ids = append(ids, com.ComID)
+    }
+  }
+  if strings.Join(ids, ",") != "c11" {
+    t.Errorf("pages listed %v, expected c11", ids)
+  }
+}
+
+func TestLocalLedgerStats(t *testing.T) {
+  merchant, customer := newTestLedgers(t)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"sale","item":"chair","price":"30","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"sale","amount":"30"}`)
+  submit(t, merchant, `{"docType":"Delivery","spec":"SellItem","comID":"sale","courier":"DHL"}`)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"paid","item":"lamp","price":"5","debtor":"Shop","creditor":"Harry","creditorID":"User2@org1.hf.scc300.io"}`)
+  submit(t, customer, `{"docType":"Pay","spec":"SellItem","comID":"paid","amount":"5"}`)
+  submit(t, merchant, `{"docType":"Offer","spec":"SellItem","comID":"open","item":"sofa","price":"300","debtor":"Shop","creditor":"Sally","creditorID":"User2@org1.hf.scc300.io"}`)
+
+  // ==== As of now, and once every deadline has passed ==== //
+  tests := []struct {
+    asOf        time.Time
+    counts      map[string]int
+    reputation  float64  // of the debtor
+    unpaid      float64  // of the creditor of the unpaid commitment, -1 until it has expired
+  }{
+    {time.Time{}, map[string]int{"conditional": 1, "active": 1, "discharged": 1}, 1, -1},
+    {time.Now().AddDate(0, 0, 8), map[string]int{"expired": 1, "violated": 1, "discharged": 1}, 0.5, 0},
+  }
+  for _, test := range tests {
+    stats, err := merchant.GetCommitmentStats("SellItem", "Delivery.courier", test.asOf)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if stats.Total != 3 {
+      t.Errorf("%d commitments as of %v, expected 3", stats.Total, test.asOf)
+    }
+    for _, state := range CurrentStates {
+      if stats.Counts[state] != test.counts[state] {
+        t.Errorf("%d %s commitments as of %v, expected %d", stats.Counts[state], state, test.asOf, test.counts[state])
+      }
+    }
+    shop := stats.Debtors["Shop"]
+    if shop == nil || shop.Total != 3 || shop.Reputation == nil || *shop.Reputation != test.reputation {
+      t.Errorf("debtor stats as of %v = %+v, expected a reputation of %v", test.asOf, shop, test.reputation)
+    }
+    // Creditors are rated on detaching their commitments, whatever the debtor did after
+    harry := stats.Creditors["Harry"]
+    if harry == nil || harry.Total != 2 || harry.Detached != 2 || harry.Reputation == nil || *harry.Reputation != 1 {
+      t.Errorf("creditor stats as of %v = %+v, expected 2 commitments detached", test.asOf, harry)
+    }
+    sally := stats.Creditors["Sally"]
+    if sally == nil || (test.unpaid < 0) != (sally.Reputation == nil) || (sally.Reputation != nil && *sally.Reputation != test.unpaid) {
+      t.Errorf("creditor stats as of %v = %+v, expected a reputation of %v", test.asOf, sally, test.unpaid)
+    }
+    if dhl := stats.Groups["DHL"]; dhl == nil || dhl.Total != 1 {
+      t.Errorf("DHL stats as of %v = %+v, expected 1 commitment", test.asOf, dhl)
+    }
+  }
+}
@@ -1,12 +1,16 @@
 package blockchain
 
 import (
-	"fmt"
   "errors"
   "encoding/json"
+  "strconv"
+  "time"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
 )
 
+// Format of the dates stored by the chaincode (in UTC)
+const TimeFormat = "Mon Jan _2 15:04:05 2006"
+
 // Mapping of commitment state to commitment query functions
 var comStateFunctions = map[string]interface{}{
   "created": "getCreatedCommitments",
@@ -14,12 +18,86 @@ var comStateFunctions = map[string]interface{}{
   "expired": "getExpiredCommitments",
   "discharged": "getDischargedCommitments",
   "violated": "getViolatedCommitments",
+  "cancelled": "getCancelledCommitments",
+  "released": "getReleasedCommitments",
 }
 
-// Represents a single commitment with an ID and slice of states
+// The current states commitments are grouped by in GetCommitmentsByAllStates, in lifecycle order:
+// conditional (created, waiting to be detached), active (detached, waiting to be discharged) and the states
+// commitments end in
+var CurrentStates = []string{"conditional", "active", "expired", "discharged", "violated", "cancelled", "released"}
+
+// Represents a single commitment with an ID, slice of states and every occurrence of its events.
+// State, Deadline and TimeRemaining (in seconds) are as of the time of the query
 type Commitment struct {
   ComID    string
+  Spec     string
+  SpecVersion int
+  State    string
+  Transitions map[string]string
+  Deadline string
+  TimeRemaining int64
+  Debtor   string
+  Creditor string
+  DebtorID   *Party
+  CreditorID *Party
   States []ComState
+  Events map[string][]map[string]interface{}
+}
+
+// A page of commitments, with the bookmark to obtain the next page with ("" on the last page)
+type CommitmentPage struct {
+  Commitments          []Commitment  `json:"commitments"`
+  FetchedRecordsCount  int           `json:"fetchedRecordsCount"`
+  Bookmark             string        `json:"bookmark"`
+}
+
+// Narrows the commitments listed by GetFilteredCommitmentsPage. Empty fields match any commitment,
+// and every argument predicate must hold for at least one occurrence of the argument
+type CommitmentFilter struct {
+  Debtor         string     // Debtor - the current debtor
+  Creditor       string     // Creditor - the current creditor
+  CreatedAfter   time.Time  // CreatedAfter - created at or after this time
+  CreatedBefore  time.Time  // CreatedBefore - created before this time
+  Args           []string   // Args - predicates on event arguments such as item=Chair, price>20 or Pay.amount>=10 (=, !=, >, >=, < or <=)
+}
+
+// Whether the filter matches every commitment
+func (filter CommitmentFilter) IsZero() bool {
+  return filter.Debtor == "" && filter.Creditor == "" && filter.CreatedAfter.IsZero() && filter.CreatedBefore.IsZero() && len(filter.Args) == 0
+}
+
+// Commitment counts and timings, of every commitment of a spec or those of one agent. Counts and Percentages are
+// keyed by current state (see CurrentStates), average times are in seconds, Detached counts the commitments detached
+// whatever their current state, and the reputation is the share of the commitments discharged rather than violated,
+// or for creditors detached rather than expired, from 0 to 1 (nil if none has been either)
+type Stats struct {
+  Total               int                 `json:"total"`
+  Counts              map[string]int      `json:"counts"`
+  Percentages         map[string]float64  `json:"percentages"`
+  AvgTimeToDetach     int64               `json:"avgTimeToDetach"`
+  AvgTimeToDischarge  int64               `json:"avgTimeToDischarge"`
+  Detached            int                 `json:"detached"`
+  Reputation          *float64            `json:"reputation"`
+}
+
+// The stats of the commitments of a spec in total, per debtor, per creditor and per value of the GroupBy event
+// argument (if any, e.g. Delivery.courier)
+type CommitmentStats struct {
+  Spec       string             `json:"spec"`
+  AsOf       string             `json:"asOf"`
+  *Stats
+  Debtors    map[string]*Stats  `json:"debtors"`
+  Creditors  map[string]*Stats  `json:"creditors"`
+  GroupBy    string             `json:"groupBy"`
+  Groups     map[string]*Stats  `json:"groups"`
+}
+
+// A page of rich query results (a JSON array of {Key, Record} objects), with the bookmark of the next page
+type QueryPage struct {
+  Records              json.RawMessage  `json:"records"`
+  FetchedRecordsCount  int              `json:"fetchedRecordsCount"`
+  Bookmark             string           `json:"bookmark"`
 }
 
 // Represents a single commitment state - each has a name and a map of data associated with that state
@@ -28,11 +106,29 @@ type ComState struct {
   Data  map[string]interface{}
 }
 
+// A client identity bound to the debtor or creditor role of a commitment
+type Party struct {
+  MSPID  string  `json:"mspID"`
+  Name   string  `json:"name"`  // Name - the common name of the client's certificate (e.g. User2@org1.hf.scc300.io)
+}
+
+// A single write to one of the keys of a commitment, with the transaction and submitter that made it
+type HistoryEntry struct {
+  Key        string
+  TxID       string
+  Timestamp  string
+  MSPID      string
+  Submitter  string
+  IsDelete   bool
+  Value      json.RawMessage
+}
+
 // A commitment specification
 type Spec struct {
   ObjectType  string `json:"docType"`  // docType - used to distinguish the various types of objects in state database
   Name        string `json:"name"`     // Spec name - the name of the specification
   Source      string `json:"source"`   // Source - string to store spec source code (.quark file)
+  Version     int    `json:"version"`  // Version - the version of the spec, starting at 1
 }
 
 // GetSpec - query the chaincode to get the state of a spec
@@ -48,46 +144,248 @@ func (setup *FabricSetup) GetSpec(name string) (res *Spec, err error) {
 
   response, er := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: [][]byte{[]byte(args[1])}})
   if er != nil {
-    return com, fmt.Errorf("failed to query: %v", er)
+    return com, wrapError("failed to query", er)
   }
 
   json.Unmarshal([]byte(response.Payload), &com)
   return com, nil
 }
 
-// GetCommitments - query the chaincode to obtain commitments for a particular state
-// States: created, detached, expired, discharged, violated
-func (setup *FabricSetup) GetCommitments(comName string, comState string) (coms[] Commitment, err error) {
+// GetSpecVersions - query the chaincode to obtain every version of a spec, oldest first
+func (setup *FabricSetup) GetSpecVersions(name string) (versions []Spec, err error) {
+
+  // Prepare arguments
+  var args []string
+  args = append(args, "getSpecVersions")
+  args = append(args, name)
+
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: [][]byte{[]byte(args[1])}})
+  if err != nil {
+    return versions, wrapError("failed to query", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), &versions)
+  return versions, nil
+}
+
+// GetCommitments - query the chaincode to obtain commitments for a particular state as of a date
+// (the time of the query if zero)
+// States: created, detached, expired, discharged, violated, cancelled, released
+func (setup *FabricSetup) GetCommitments(comName string, comState string, asOf time.Time) (coms[] Commitment, err error) {
 
   // Prepare results
   commitments := []Commitment{}
-  var chaincodeFunc string
 
-  // Sanity check
-  if chaincodeFunc = comStateFunctions[comState].(string); chaincodeFunc == "" {
-    return commitments, errors.New("Unsupported commitment state chosen")
+  // Prepare arguments
+  args, err := commitmentsQueryArgs(comName, comState, asOf)
+  if err != nil {
+    return commitments, err
+  }
+
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: strArrToByteArr(args[1:])})
+  if err != nil {
+    return commitments, wrapError("failed to query", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), &commitments)
+  return commitments, nil
+}
+
+// GetCommitmentsByAllStates - query the chaincode to obtain every commitment of a spec grouped by its current state
+// (see CurrentStates) as of a date (the time of the query if zero)
+func (setup *FabricSetup) GetCommitmentsByAllStates(comName string, asOf time.Time) (map[string][]Commitment, error) {
+
+  // Prepare results
+  byState := map[string][]Commitment{}
+
+  // Prepare arguments
+  args := commitmentsByAllStatesArgs(comName, asOf)
+
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: strArrToByteArr(args[1:])})
+  if err != nil {
+    return byState, wrapError("failed to query", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), &byState)
+  return byState, nil
+}
+
+// GetCommitmentsPage - query the chaincode to obtain a page of the commitments in a particular state as of a
+// date (the time of the query if zero), starting after the bookmark ("" for the first page)
+func (setup *FabricSetup) GetCommitmentsPage(comName string, comState string, asOf time.Time, pageSize int, bookmark string) (*CommitmentPage, error) {
+
+  // Prepare results
+  page := &CommitmentPage{Commitments: []Commitment{}}
+
+  // Prepare arguments
+  args := commitmentsPageArgs(comName, comState, asOf, pageSize, bookmark)
+
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: strArrToByteArr(args[1:])})
+  if err != nil {
+    return page, wrapError("failed to query", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), page)
+  return page, nil
+}
+
+// GetCommitment - query the chaincode to obtain a single commitment with its full lifecycle
+// (specName may be "" to find the commitment whatever its spec)
+func (setup *FabricSetup) GetCommitment(specName string, comID string) (*Commitment, error) {
+
+  // Prepare arguments
+  var args []string
+  args = append(args, "getCommitment")
+  args = append(args, specName)
+  args = append(args, comID)
+
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: strArrToByteArr(args[1:])})
+  if err != nil {
+    return nil, wrapError("failed to query", err)
   }
 
+  com := &Commitment{}
+  json.Unmarshal([]byte(response.Payload), com)
+  return com, nil
+}
+
+// GetCommitmentHistory - query the chaincode to obtain every write made to a commitment, oldest first
+func (setup *FabricSetup) GetCommitmentHistory(comID string) (history []HistoryEntry, err error) {
+
   // Prepare arguments
+  var args []string
+  args = append(args, "getCommitmentHistory")
+  args = append(args, comID)
+
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: [][]byte{[]byte(args[1])}})
+  if err != nil {
+    return history, wrapError("failed to query history", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), &history)
+  return history, nil
+}
+
+// GetCommitmentStats - query the chaincode to obtain the stats of the commitments of a spec as of a date (the time of
+// the query if zero), also grouped by an event argument unless groupBy is ""
+func (setup *FabricSetup) GetCommitmentStats(comName string, groupBy string, asOf time.Time) (*CommitmentStats, error) {
+  args := commitmentStatsArgs(comName, groupBy, asOf)
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: strArrToByteArr(args[1:])})
+  if err != nil {
+    return nil, wrapError("failed to query", err)
+  }
+
+  stats := &CommitmentStats{}
+  json.Unmarshal([]byte(response.Payload), stats)
+  return stats, nil
+}
+
+// Prepares the chaincode function and arguments that obtain the stats of the commitments of a spec
+func commitmentStatsArgs(comName string, groupBy string, asOf time.Time) []string {
+  args := []string{"getCommitmentStats", comName, groupBy}
+  if !asOf.IsZero() {
+    args = append(args, asOf.UTC().Format(TimeFormat))
+  }
+  return args
+}
+
+// Prepares the chaincode function and arguments that obtain the commitments of a spec in a particular state
+func commitmentsQueryArgs(comName string, comState string, asOf time.Time) ([]string, error) {
+  chaincodeFunc, ok := comStateFunctions[comState].(string)
+  if !ok {
+    return nil, errors.New("Unsupported commitment state chosen")
+  }
+
   var args []string
   args = append(args, chaincodeFunc)
   args = append(args, comName)
 
   // Calls getDetachedCommitments/getDischargedCommitments in the chaincode logic with extra arg
   // Prevents repetition of code by using a boolean flag
-  if chaincodeFunc == "getExpiredCommitments" || chaincodeFunc == "getViolatedCommitments" {
-    args = append(args, "true")
-  } else {
-    args = append(args, "false")
+  switch chaincodeFunc {
+    case "getExpiredCommitments", "getViolatedCommitments":
+      args = append(args, "true")
+    case "getDetachedCommitments", "getDischargedCommitments":
+      args = append(args, "false")
+  }
+
+  // The chaincode evaluates deadlines as of the transaction timestamp unless given a date
+  if !asOf.IsZero() {
+    args = append(args, asOf.UTC().Format(TimeFormat))
+  }
+  return args, nil
+}
+
+// Prepares the chaincode function and arguments that obtain the commitments of a spec grouped by current state
+func commitmentsByAllStatesArgs(comName string, asOf time.Time) []string {
+  args := []string{"getCommitmentsByAllStates", comName}
+  if !asOf.IsZero() {
+    args = append(args, asOf.UTC().Format(TimeFormat))
+  }
+  return args
+}
+
+// Prepares the chaincode function and arguments that obtain a page of the commitments of a spec in a particular state
+func commitmentsPageArgs(comName string, comState string, asOf time.Time, pageSize int, bookmark string) []string {
+  args := []string{"getCommitmentsWithPagination", comName, comState, strconv.Itoa(pageSize), bookmark}
+  if !asOf.IsZero() {
+    args = append(args, asOf.UTC().Format(TimeFormat))
   }
+  return args
+}
 
-  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: [][]byte{[]byte(args[1]), []byte(args[2])}})
+// GetFilteredCommitmentsPage - query the chaincode to obtain a page of the commitments in a particular state ("" for
+// any) matching a filter as of a date (the time of the query if zero), starting after the bookmark ("" for the first page)
+func (setup *FabricSetup) GetFilteredCommitmentsPage(comName string, comState string, filter CommitmentFilter, asOf time.Time, pageSize int, bookmark string) (*CommitmentPage, error) {
+  page := &CommitmentPage{Commitments: []Commitment{}}
+  args, err := filteredCommitmentsPageArgs(comName, comState, filter, asOf, pageSize, bookmark)
   if err != nil {
-    return commitments, fmt.Errorf("failed to query: %v", err)
+    return page, err
   }
 
-  json.Unmarshal([]byte(response.Payload), &commitments)
-  return commitments, nil
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: strArrToByteArr(args[1:])})
+  if err != nil {
+    return page, wrapError("failed to query", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), page)
+  return page, nil
+}
+
+// Prepares the chaincode function and arguments that obtain a page of the commitments of a spec matching a filter.
+// The chaincode takes the filter as JSON with its dates in TimeFormat
+func filteredCommitmentsPageArgs(comName string, comState string, filter CommitmentFilter, asOf time.Time, pageSize int, bookmark string) ([]string, error) {
+  chaincodeFilter := map[string]interface{}{"debtor": filter.Debtor, "creditor": filter.Creditor, "args": filter.Args}
+  if !filter.CreatedAfter.IsZero() {
+    chaincodeFilter["createdAfter"] = filter.CreatedAfter.UTC().Format(TimeFormat)
+  }
+  if !filter.CreatedBefore.IsZero() {
+    chaincodeFilter["createdBefore"] = filter.CreatedBefore.UTC().Format(TimeFormat)
+  }
+  filterJSON, err := json.Marshal(chaincodeFilter)
+  if err != nil {
+    return nil, err
+  }
+
+  args := []string{"getFilteredCommitments", comName, comState, string(filterJSON), strconv.Itoa(pageSize), bookmark}
+  if !asOf.IsZero() {
+    args = append(args, asOf.UTC().Format(TimeFormat))
+  }
+  return args, nil
+}
+
+// RichQueryPage - query the chaincode to perform an ad hoc rich query a page at a time in key order, starting after the
+// bookmark ("" for the first page)
+func (setup *FabricSetup) RichQueryPage(query string, pageSize int, bookmark string) (*QueryPage, error) {
+  page := &QueryPage{}
+  args := []string{query, strconv.Itoa(pageSize), bookmark}
+  response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: "richQueryWithPagination", Args: strArrToByteArr(args)})
+  if err != nil {
+    return page, wrapError("failed to perform rich query", err)
+  }
+
+  json.Unmarshal([]byte(response.Payload), page)
+  return page, nil
 }
 
 // RichQuery - query the chaincode to perform an ad hoc rich query based on input
@@ -100,7 +398,7 @@ func (setup *FabricSetup) RichQuery(query string) (string, error) {
 
   response, err := setup.client.Query(channel.Request{ChaincodeID: setup.ChainCodeID, Fcn: args[0], Args: [][]byte{[]byte(args[1])}})
   if err != nil {
-    return "", fmt.Errorf("failed to perform rich query: %v", err)
+    return "", wrapError("failed to perform rich query", err)
   }
 
   return string(response.Payload), nil
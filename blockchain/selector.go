@@ -0,0 +1,356 @@
+package blockchain
+
+import (
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// The subset of CouchDB's query syntax (Mango) understood by the local ledger.
+// Index hints (use_index) and field projections are accepted and ignored.
+type couchQuery struct {
+  Selector  map[string]interface{}  `json:"selector"`
+  Sort      []interface{}           `json:"sort"`
+  Limit     int                     `json:"limit"`
+  Skip      int                     `json:"skip"`
+}
+
+// Evaluates a CouchDB query against the given key/value pairs, as the peer would against its state database.
+// Values that aren't JSON objects are never matched, as CouchDB stores them as attachments.
+func runCouchQuery(kvs []*queryresult.KV, query string) ([]*queryresult.KV, error) {
+  var q couchQuery
+  if err := json.Unmarshal([]byte(query), &q); err != nil {
+    return nil, fmt.Errorf("invalid query: %v", err)
+  }
+  if q.Selector == nil {
+    return nil, fmt.Errorf("invalid query: selector is required")
+  }
+
+  // Keep the documents matching the selector
+  results := []*queryresult.KV{}
+  docs := map[*queryresult.KV]map[string]interface{}{}
+  for _, kv := range kvs {
+    var doc map[string]interface{}
+    if err := json.Unmarshal(kv.Value, &doc); err != nil {
+      continue
+    }
+    // CouchDB stores each value under its key as _id
+    doc["_id"] = kv.Key
+    match, err := matchSelector(doc, q.Selector)
+    if err != nil {
+      return nil, err
+    }
+    if match {
+      results = append(results, kv)
+      docs[kv] = doc
+    }
+  }
+
+  // Sort by each field in turn, e.g. "sort": ["spec", {"comID": "desc"}]
+  for i := len(q.Sort) - 1; i >= 0; i-- {
+    field, desc, err := sortField(q.Sort[i])
+    if err != nil {
+      return nil, err
+    }
+    sort.SliceStable(results, func(a, b int) bool {
+      x, xok := lookupField(docs[results[a]], field)
+      y, yok := lookupField(docs[results[b]], field)
+      if !xok || !yok {
+        // Documents without the field go last
+        return xok && !yok
+      }
+      if desc {
+        return collate(x, y) > 0
+      }
+      return collate(x, y) < 0
+    })
+  }
+
+  // Page through the results
+  if q.Skip > 0 {
+    if q.Skip > len(results) {
+      q.Skip = len(results)
+    }
+    results = results[q.Skip:]
+  }
+  if q.Limit > 0 && q.Limit < len(results) {
+    results = results[:q.Limit]
+  }
+  return results, nil
+}
+
+// Checks a document against a selector, where every field condition and combination operator must hold
+func matchSelector(doc map[string]interface{}, selector map[string]interface{}) (bool, error) {
+  for key, cond := range selector {
+    var match bool
+    var err error
+    switch key {
+      case "$and", "$or", "$nor":
+        match, err = matchCombination(doc, key, cond)
+      case "$not":
+        sub, ok := cond.(map[string]interface{})
+        if !ok {
+          return false, fmt.Errorf("invalid query: $not expects a selector")
+        }
+        match, err = matchSelector(doc, sub)
+        match = !match
+      default:
+        if strings.HasPrefix(key, "$") {
+          return false, fmt.Errorf("invalid query: unsupported operator %s", key)
+        }
+        value, exists := lookupField(doc, key)
+        match, err = matchCondition(value, exists, cond)
+    }
+    if err != nil || !match {
+      return false, err
+    }
+  }
+  return true, nil
+}
+
+// Checks a document against a list of selectors combined with $and, $or or $nor
+func matchCombination(doc map[string]interface{}, op string, cond interface{}) (bool, error) {
+  selectors, ok := cond.([]interface{})
+  if !ok {
+    return false, fmt.Errorf("invalid query: %s expects an array of selectors", op)
+  }
+  matches := 0
+  for _, s := range selectors {
+    sub, ok := s.(map[string]interface{})
+    if !ok {
+      return false, fmt.Errorf("invalid query: %s expects an array of selectors", op)
+    }
+    match, err := matchSelector(doc, sub)
+    if err != nil {
+      return false, err
+    }
+    if match {
+      matches++
+    }
+  }
+  switch op {
+    case "$and":
+      return matches == len(selectors), nil
+    case "$or":
+      return matches > 0, nil
+  }
+  return matches == 0, nil
+}
+
+// Checks a field value against a condition: an implicit equality, a nested selector
+// or a set of operators such as {"$gte": 5, "$lt": 10}
+func matchCondition(value interface{}, exists bool, cond interface{}) (bool, error) {
+  ops, ok := cond.(map[string]interface{})
+  if !ok {
+    return exists && collate(value, cond) == 0, nil
+  }
+  if !hasOperators(ops) {
+    sub, ok := value.(map[string]interface{})
+    if !ok {
+      return false, nil
+    }
+    return matchSelector(sub, ops)
+  }
+  for op, arg := range ops {
+    match, err := matchOperator(value, exists, op, arg)
+    if err != nil || !match {
+      return false, err
+    }
+  }
+  return true, nil
+}
+
+// Applies a single condition operator to a field value
+func matchOperator(value interface{}, exists bool, op string, arg interface{}) (bool, error) {
+  switch op {
+    case "$exists":
+      want, ok := arg.(bool)
+      if !ok {
+        return false, fmt.Errorf("invalid query: $exists expects a boolean")
+      }
+      return exists == want, nil
+    case "$ne":
+      return !exists || collate(value, arg) != 0, nil
+    case "$nin":
+      in, err := matchIn(value, arg)
+      return exists && !in, err
+    case "$not":
+      match, err := matchCondition(value, exists, arg)
+      return !match, err
+    case "$elemMatch", "$allMatch":
+      return matchElements(value, op, arg)
+  }
+  if !exists {
+    return false, nil
+  }
+  switch op {
+    case "$eq":
+      return collate(value, arg) == 0, nil
+    case "$gt", "$gte", "$lt", "$lte":
+      // Range operators only compare values of the same type
+      if typeRank(value) != typeRank(arg) {
+        return false, nil
+      }
+      cmp := collate(value, arg)
+      switch op {
+        case "$gt":
+          return cmp > 0, nil
+        case "$gte":
+          return cmp >= 0, nil
+        case "$lt":
+          return cmp < 0, nil
+      }
+      return cmp <= 0, nil
+    case "$in":
+      return matchIn(value, arg)
+    case "$regex":
+      pattern, ok := arg.(string)
+      str, isStr := value.(string)
+      if !ok {
+        return false, fmt.Errorf("invalid query: $regex expects a string")
+      }
+      re, err := regexp.Compile(pattern)
+      if err != nil {
+        return false, fmt.Errorf("invalid query: %v", err)
+      }
+      return isStr && re.MatchString(str), nil
+  }
+  return false, fmt.Errorf("invalid query: unsupported operator %s", op)
+}
+
+// Checks the elements of an array field against a condition, e.g. {"$elemMatch": {"item": "Chair"}}:
+// $elemMatch holds if any element matches and $allMatch if every element does (and there is one)
+func matchElements(value interface{}, op string, arg interface{}) (bool, error) {
+  elems, ok := value.([]interface{})
+  if !ok {
+    return false, nil
+  }
+  matches := 0
+  for _, elem := range elems {
+    match, err := matchCondition(elem, true, arg)
+    if err != nil {
+      return false, err
+    }
+    if match {
+      matches++
+    }
+  }
+  if op == "$elemMatch" {
+    return matches > 0, nil
+  }
+  return len(elems) > 0 && matches == len(elems), nil
+}
+
+// Checks whether a value is one of the values of an $in/$nin array
+func matchIn(value interface{}, arg interface{}) (bool, error) {
+  values, ok := arg.([]interface{})
+  if !ok {
+    return false, fmt.Errorf("invalid query: $in and $nin expect an array")
+  }
+  for _, v := range values {
+    if collate(value, v) == 0 {
+      return true, nil
+    }
+  }
+  return false, nil
+}
+
+// Whether a condition object consists of operators rather than nested fields
+func hasOperators(cond map[string]interface{}) bool {
+  for key := range cond {
+    if strings.HasPrefix(key, "$") {
+      return true
+    }
+  }
+  return false
+}
+
+// Resolves a (possibly dotted) field name in a document, e.g. "data.price"
+func lookupField(doc map[string]interface{}, field string) (interface{}, bool) {
+  var value interface{} = doc
+  for _, part := range strings.Split(field, ".") {
+    obj, ok := value.(map[string]interface{})
+    if !ok {
+      return nil, false
+    }
+    if value, ok = obj[part]; !ok {
+      return nil, false
+    }
+  }
+  return value, true
+}
+
+// Reads a sort entry, either "field" or {"field": "asc"|"desc"}
+func sortField(entry interface{}) (field string, desc bool, err error) {
+  switch s := entry.(type) {
+    case string:
+      return s, false, nil
+    case map[string]interface{}:
+      for field, dir := range s {
+        return field, dir == "desc", nil
+      }
+  }
+  return "", false, fmt.Errorf("invalid query: unsupported sort %v", entry)
+}
+
+// Orders JSON values the way CouchDB collates them: null, false, true, numbers, strings, arrays, objects
+func collate(x interface{}, y interface{}) int {
+  rx, ry := typeRank(x), typeRank(y)
+  if rx != ry {
+    if rx < ry {
+      return -1
+    }
+    return 1
+  }
+  switch xv := x.(type) {
+    case float64:
+      yv := y.(float64)
+      if xv < yv {
+        return -1
+      } else if xv > yv {
+        return 1
+      }
+      return 0
+    case string:
+      return strings.Compare(xv, y.(string))
+    case []interface{}:
+      yv := y.([]interface{})
+      for i := 0; i < len(xv) && i < len(yv); i++ {
+        if cmp := collate(xv[i], yv[i]); cmp != 0 {
+          return cmp
+        }
+      }
+      return len(xv) - len(yv)
+    case map[string]interface{}:
+      if reflect.DeepEqual(x, y) {
+        return 0
+      }
+      return strings.Compare(fmt.Sprint(x), fmt.Sprint(y))
+  }
+  return 0
+}
+
+// The position of a JSON value's type in CouchDB's collation order
+func typeRank(value interface{}) int {
+  switch v := value.(type) {
+    case nil:
+      return 0
+    case bool:
+      if v {
+        return 2
+      }
+      return 1
+    case float64:
+      return 3
+    case string:
+      return 4
+    case []interface{}:
+      return 5
+  }
+  return 6
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+  "strings"
+  "testing"
+
+  "github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// Documents shaped like the chaincode's commitment records, keyed as they are in state
+var selectorDocs = []*queryresult.KV{
+  {Key: "c1", Value: []byte(`{"docType":"commitment","spec":"SellItem","comID":"c1","state":"created","price":30,"createdAt":"2018-01-05T10:00:00Z","transitions":{"created":"Fri Jan  5 10:00:00 2018"},"events":{"Pay":[]}}`)},
+  {Key: "c2", Value: []byte(`{"docType":"commitment","spec":"SellItem","comID":"c2","state":"detached","price":5,"createdAt":"2018-01-06T10:00:00Z","transitions":{"created":"Sat Jan  6 10:00:00 2018","detached":"Sun Jan  7 10:00:00 2018"},"events":{"Pay":[{"amount":"5"}]}}`)},
+  {Key: "c3", Value: []byte(`{"docType":"commitment","spec":"SellBook","comID":"c3","state":"discharged","price":12.5,"transitions":{"created":"Sun Jan  7 10:00:00 2018"},"events":{"Pay":[{"amount":"7"},{"amount":"5.5"}]}}`)},
+  {Key: "s1", Value: []byte(`{"docType":"spec","name":"SellItem"}`)},
+  {Key: "raw", Value: []byte(`not a JSON object`)},
+}
+
+func TestRunCouchQuery(t *testing.T) {
+  tests := []struct {
+    name   string
+    query  string
+    keys   string  // keys of the results expected, in order
+  }{
+    {"equality", `{"selector":{"docType":"commitment","spec":"SellItem"}}`, "c1,c2"},
+    {"every document", `{"selector":{}}`, "c1,c2,c3,s1"},
+    {"no match", `{"selector":{"docType":"submitter"}}`, ""},
+    {"$eq and $ne", `{"selector":{"docType":{"$eq":"commitment"},"state":{"$ne":"created"}}}`, "c2,c3"},
+    {"numeric range", `{"selector":{"price":{"$gt":5,"$lte":30}}}`, "c1,c3"},
+    {"ranges only compare the same type", `{"selector":{"price":{"$gt":"0"}}}`, ""},
+    {"string range", `{"selector":{"createdAt":{"$gte":"2018-01-06T00:00:00Z"}}}`, "c2"},
+    {"$in and $nin", `{"selector":{"state":{"$in":["created","discharged"]},"spec":{"$nin":["SellBook"]}}}`, "c1"},
+    {"$exists", `{"selector":{"docType":"commitment","createdAt":{"$exists":false}}}`, "c3"},
+    {"nested field", `{"selector":{"transitions.detached":{"$exists":true}}}`, "c2"},
+    {"nested selector", `{"selector":{"transitions":{"created":"Fri Jan  5 10:00:00 2018"}}}`, "c1"},
+    {"$or", `{"selector":{"$or":[{"state":"created"},{"spec":"SellBook"}]}}`, "c1,c3"},
+    {"$and", `{"selector":{"$and":[{"docType":"commitment"},{"$or":[{"price":5},{"price":30}]}]}}`, "c1,c2"},
+    {"$nor", `{"selector":{"docType":"commitment","$nor":[{"state":"created"},{"state":"detached"}]}}`, "c3"},
+    {"$not", `{"selector":{"docType":"commitment","$not":{"spec":"SellItem"}}}`, "c3"},
+    {"$elemMatch", `{"selector":{"events.Pay":{"$elemMatch":{"amount":"5"}}}}`, "c2"},
+    {"$allMatch", `{"selector":{"events.Pay":{"$allMatch":{"amount":{"$regex":"^[0-9.]+$"}}}}}`, "c2,c3"},
+    {"$regex", `{"selector":{"comID":{"$regex":"^c[23]$"}}}`, "c2,c3"},
+    {"_id", `{"selector":{"_id":{"$gt":"c1"},"docType":"commitment"}}`, "c2,c3"},
+    {"sort descending", `{"selector":{"docType":"commitment"},"sort":[{"price":"desc"}]}`, "c1,c3,c2"},
+    {"sort by several fields", `{"selector":{"docType":"commitment"},"sort":["spec",{"comID":"desc"}]}`, "c3,c2,c1"},
+    {"documents without the sort field go last", `{"selector":{"docType":"commitment"},"sort":["createdAt"]}`, "c1,c2,c3"},
+    {"limit and skip", `{"selector":{"docType":"commitment"},"sort":["comID"],"skip":1,"limit":1}`, "c2"},
+    {"skip past the end", `{"selector":{"docType":"commitment"},"skip":5}`, ""},
+    {"index hints are ignored", `{"selector":{"spec":"SellBook"},"use_index":["_design/indexCommitmentDoc","indexCommitment"]}`, "c3"},
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      results, err := runCouchQuery(selectorDocs, test.query)
+      if err != nil {
+        t.Fatal(err)
+      }
+      keys := []string{}
+      for _, kv := range results {
+        keys = append(keys, kv.Key)
+      }
+      if strings.Join(keys, ",") != test.keys {
+        t.Errorf("results %v, expected %s", keys, test.keys)
+      }
+    })
+  }
+}
+
+func TestRunCouchQueryErrors(t *testing.T) {
+  tests := []struct {
+    query  string
+    err    string  // a part of the error expected
+  }{
+    {`not JSON`, "invalid query"},
+    {`{"sort":["comID"]}`, "selector is required"},
+    {`{"selector":{"$where":"true"}}`, "unsupported operator $where"},
+    {`{"selector":{"price":{"$mod":[2,0]}}}`, "unsupported operator $mod"},
+    {`{"selector":{"$or":{"state":"created"}}}`, "$or expects an array"},
+    {`{"selector":{"state":{"$in":"created"}}}`, "expect an array"},
+    {`{"selector":{"createdAt":{"$exists":"yes"}}}`, "$exists expects a boolean"},
+    {`{"selector":{"comID":{"$regex":"("}}}`, "invalid query"},
+  }
+
+  for _, test := range tests {
+    _, err := runCouchQuery(selectorDocs, test.query)
+    if err == nil || !strings.Contains(err.Error(), test.err) {
+      t.Errorf("%s: got error %v, expected %q", test.query, err, test.err)
+    }
+  }
+}
@@ -2,11 +2,13 @@ package blockchain
 
 import (
 	"fmt"
+	"sync"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
 	mspclient "github.com/hyperledger/fabric-sdk-go/pkg/client/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/resmgmt"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/errors/retry"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
 	packager "github.com/hyperledger/fabric-sdk-go/pkg/fab/ccpackager/gopackager"
@@ -33,6 +35,16 @@ type FabricSetup struct {
 	admin           *resmgmt.Client
 	sdk             *fabsdk.FabricSDK
 	event           *event.Client
+	msp             *mspclient.Client
+	users           *userClients
+	transitions     *transitionFeed
+	transitionsReg  fab.Registration
+}
+
+// Channel clients of the users transacting through this setup, created on first use
+type userClients struct {
+	lock     sync.Mutex
+	clients  map[string]*channel.Client
 }
 
 // Initialize reads the configuration file and sets up the client, chain and event hub
@@ -49,6 +61,8 @@ func (setup *FabricSetup) Initialize() error {
 		return errors.WithMessage(err, "failed to create SDK")
 	}
 	setup.sdk = sdk
+	setup.users = &userClients{clients: make(map[string]*channel.Client)}
+	setup.transitions = &transitionFeed{}
 	fmt.Println("SDK created")
 
 	// The resource management client is responsible for managing channels (create/update channel)
@@ -68,6 +82,7 @@ func (setup *FabricSetup) Initialize() error {
 	if err != nil {
 		return errors.WithMessage(err, "failed to create MSP client")
 	}
+	setup.msp = mspClient
 	adminIdentity, err := mspClient.GetSigningIdentity(setup.OrgAdmin)
 	if err != nil {
 		return errors.WithMessage(err, "failed to get admin signing identity")
@@ -126,16 +141,65 @@ func (setup *FabricSetup) InstallAndInstantiateCC() error {
 	fmt.Println("Channel client created")
 
 	// Creation of the client which will enables access to our channel events
-	setup.event, err = event.New(clientContext)
+	// Full blocks are needed for chaincode event payloads (filtered blocks leave them out)
+	setup.event, err = event.New(clientContext, event.WithBlockEvents())
 	if err != nil {
 		return errors.WithMessage(err, "failed to create new event client")
 	}
 	fmt.Println("Event client created")
 
+	// Long-lived subscription fanning commitment transitions out to the web applications
+	reg, notifier, err := setup.event.RegisterChaincodeEvent(setup.ChainCodeID, "eventInvoke")
+	if err != nil {
+		return errors.WithMessage(err, "failed to subscribe to chaincode events")
+	}
+	setup.transitionsReg = reg
+	go func() {
+		for ccEvent := range notifier {
+			setup.transitions.publish(ccEvent.TxID, ccEvent.Payload)
+		}
+	}()
+
 	fmt.Println("Chaincode Installation & Instantiation Successful")
 	return nil
 }
 
 func (setup *FabricSetup) CloseSDK() {
+	if setup.transitionsReg != nil {
+		setup.event.Unregister(setup.transitionsReg)
+	}
 	setup.sdk.Close()
 }
+
+// ForUser - the ledger as seen by a user of the organisation, whose transactions are signed
+// with their own identity, loaded through the MSP client
+func (setup *FabricSetup) ForUser(userName string) (Ledger, error) {
+	if userName == setup.UserName {
+		return setup, nil
+	}
+
+	setup.users.lock.Lock()
+	defer setup.users.lock.Unlock()
+	client, ok := setup.users.clients[userName]
+	if !ok {
+		identity, err := setup.msp.GetSigningIdentity(userName)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to get signing identity of " + userName)
+		}
+		client, err = channel.New(setup.sdk.ChannelContext(setup.ChannelID, fabsdk.WithIdentity(identity)))
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to create channel client for " + userName)
+		}
+		setup.users.clients[userName] = client
+	}
+
+	user := *setup
+	user.UserName = userName
+	user.client = client
+	return &user, nil
+}
+
+// SubscribeTransitions - receive the commitment transitions of every committed transaction
+func (setup *FabricSetup) SubscribeTransitions() (<-chan Transition, func()) {
+	return setup.transitions.Subscribe()
+}
@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+  "encoding/json"
+  "fmt"
+  "time"
+)
+
+// Obtains the records of the commitments still open (created or detached), whatever their spec
+const OpenCommitmentsQuery = "{\"selector\":{\"docType\":\"commitment\",\"state\":{\"$in\":[\"created\",\"detached\"]}}}"
+
+// Number of open commitments fetched per query by the deadline watcher
+const watcherPageSize = 100
+
+// DeadlineWatcher records the failure of commitments on the ledger as their deadlines pass. Queries evaluate
+// commitments past a deadline as expired or violated, but nothing is written when the deadline passes, so the
+// watcher periodically submits an Expire (created commitments) or Violate (detached commitments) transaction
+// for each, which emits the transition to listeners (see SubscribeTransitions)
+type DeadlineWatcher struct {
+  Ledger    Ledger         // Ledger - the ledger to watch, and submit the transactions to
+  Interval  time.Duration  // Interval - time between checks
+}
+
+// The deadlines of an open commitment, as stored in its record
+type openCommitment struct {
+  Spec               string  `json:"spec"`
+  ComID              string  `json:"comID"`
+  State              string  `json:"state"`
+  DetachDeadline     string  `json:"detachDeadline"`
+  DischargeDeadline  string  `json:"dischargeDeadline"`
+}
+
+// Run checks for commitments past their deadline straight away and then every Interval, until stop is closed
+func (watcher *DeadlineWatcher) Run(stop <-chan struct{}) {
+  ticker := time.NewTicker(watcher.Interval)
+  defer ticker.Stop()
+  for {
+    if _, err := watcher.Check(time.Now()); err != nil {
+      fmt.Printf("Unable to check commitment deadlines: %v\n", err)
+    }
+    select {
+      case <-stop:
+        return
+      case <-ticker.C:
+    }
+  }
+}
+
+// Check submits an Expire or Violate transaction for every open commitment past its deadline as of now, returning
+// the number of commitments recorded. Commitments that can't be recorded (e.g. as the peer's clock is behind) are
+// reported and left for the next check
+func (watcher *DeadlineWatcher) Check(now time.Time) (int, error) {
+  overdue, err := watcher.overdue(now)
+  if err != nil {
+    return 0, err
+  }
+
+  recorded := 0
+  for _, com := range overdue {
+    var err error
+    if com.State == "created" {
+      _, err = watcher.Ledger.InvokeExpireCommitment(com.ComID)
+    } else {
+      _, err = watcher.Ledger.InvokeViolateCommitment(com.ComID)
+    }
+    if err != nil {
+      fmt.Printf("Unable to record the failure of %s commitment %s: %v\n", com.Spec, com.ComID, err)
+      continue
+    }
+    recorded++
+  }
+  return recorded, nil
+}
+
+// Obtains the open commitments past their deadline as of now. Every page is read before any transaction is
+// submitted, as commitments recorded as failed drop out of the query and would invalidate its bookmark
+func (watcher *DeadlineWatcher) overdue(now time.Time) ([]openCommitment, error) {
+  overdue := []openCommitment{}
+  bookmark := ""
+  for {
+    page, err := watcher.Ledger.RichQueryPage(OpenCommitmentsQuery, watcherPageSize, bookmark)
+    if err != nil {
+      return nil, err
+    }
+    var records []struct {
+      Key     string
+      Record  openCommitment
+    }
+    if err := json.Unmarshal(page.Records, &records); err != nil {
+      return nil, fmt.Errorf("failed to read open commitments: %v", err)
+    }
+
+    for _, record := range records {
+      com := record.Record
+      deadline := com.DetachDeadline
+      if com.State == "detached" {
+        deadline = com.DischargeDeadline
+      }
+      date, err := time.Parse(TimeFormat, deadline)
+      if err != nil {
+        fmt.Printf("Ignoring %s commitment %s with invalid deadline %q\n", com.Spec, com.ComID, deadline)
+        continue
+      }
+      if !now.Before(date) {
+        overdue = append(overdue, com)
+      }
+    }
+
+    if page.Bookmark == "" {
+      return overdue, nil
+    }
+    bookmark = page.Bookmark
+  }
+}
@@ -0,0 +1,2949 @@
+// Package network implements the SCC300 network chaincode: Quark specs, commitment data and
+// the commitment lifecycle.
+package network
+
+import (
+  "fmt"
+  "time"
+  "strconv"
+  "bytes"
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/json"
+  "errors"
+  "sort"
+  "strings"
+  "github.com/hyperledger/fabric/core/chaincode/shim"
+  "github.com/hyperledger/fabric/core/chaincode/lib/cid"
+  "github.com/hyperledger/fabric/protos/ledger/queryresult"
+  pb "github.com/hyperledger/fabric/protos/peer"
+  q "github.com/scc300/scc300-network/chaincode/quark"
+)
+
+const (
+  GetSpecsQuery = "{\"selector\":{\"docType\":\"spec\"}}"  // Obtains all registered specs
+
+  // Commitment lifecycle states
+  StateCreated = "created"
+  StateDetached = "detached"
+  StateExpired = "expired"
+  StateDischarged = "discharged"
+  StateViolated = "violated"
+  StateCancelled = "cancelled"
+  StateReleased = "released"
+
+  // Current states of commitments in progress (see currentState)
+  StateConditional = "conditional"
+  StateActive = "active"
+
+  // Commitment roles, bound to client identities when a commitment is created
+  RoleDebtor = "debtor"
+  RoleCreditor = "creditor"
+
+  // Statuses of requests refused because of what was asked for, between shim.ERRORTHRESHOLD and
+  // shim.ERROR so clients can tell them apart from failures without reading the message
+  StatusForbidden = 403
+  StatusNotFound = 404
+
+  GreenTick = "\033[92m" + "\u2713" + "\033[0m"
+  TimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// SCC300NetworkChaincode implementation of Chaincode
+type SCC300NetworkChaincode struct {
+}
+
+type Spec struct {
+  ObjectType  string `json:"docType"`  // docType - used to distinguish the various types of objects in state database
+  Name        string `json:"name"`     // Spec name - the name of the specification
+  Source      string `json:"source"`   // Source - string to store spec source code (.quark file)
+  Version     int    `json:"version"`  // Version - starts at 1 and goes up each time a new version is published (0 for specs stored before versioning)
+}
+
+type Commitment struct {
+  ComID    string     // ComID - stores this commitment ID (each commitment is unique)
+  Spec     string     // Spec - name of the spec this commitment is an instance of
+  SpecVersion int     // SpecVersion - the version of the spec this commitment was created under
+  State    string     // State - lifecycle state as of the evaluation date
+  Transitions map[string]string  // Transitions - date of each state transition, keyed by state
+  Deadline string     // Deadline - date by which the next event must occur ("" once the commitment has ended)
+  TimeRemaining int64 // TimeRemaining - seconds left until the deadline as of the evaluation date
+  Debtor   string     // Debtor - the current debtor (changes when the commitment is delegated)
+  Creditor string     // Creditor - the current creditor (changes when the commitment is assigned)
+  DebtorID   *Party   // DebtorID - client identity bound to the debtor role (nil for commitments created before roles were bound)
+  CreditorID *Party   // CreditorID - client identity bound to the creditor role
+  States []ComState   // States - slice of commitment states 
+  Events map[string][]map[string]interface{}  // Events - data of every occurrence of each event, oldest first
+}
+
+type ComState struct {
+  Name  string                    // Name - name of this particular commitment state (i.e. created, detached, discharged, expired, violated)
+  Data  map[string]interface{}    // Data - map of data associated with this state
+}
+
+// Materialized commitment record - updated on every accepted event so reads don't recompute the lifecycle
+type CommitmentRecord struct {
+  ObjectType         string             `json:"docType"`            // docType - always "commitment"
+  Spec               string             `json:"spec"`               // Spec - name of the spec this commitment is an instance of
+  SpecVersion        int                `json:"specVersion"`        // SpecVersion - version of the spec the commitment was created under, whose events and deadlines it follows
+  ComID              string             `json:"comID"`              // ComID - the commitment ID
+  State              string             `json:"state"`              // State - lifecycle state as of the last accepted event
+  Debtor             string             `json:"debtor"`             // Debtor - the current debtor, initially the debtor of the create event
+  Creditor           string             `json:"creditor"`           // Creditor - the current creditor, initially the creditor of the create event
+  DebtorID           *Party             `json:"debtorID,omitempty"`    // DebtorID - the client identity bound to the debtor role (unset for commitments created before binding)
+  CreditorID         *Party             `json:"creditorID,omitempty"`  // CreditorID - the client identity bound to the creditor role
+  Transitions        map[string]string  `json:"transitions"`        // Transitions - date of each state transition, keyed by state
+  CreatedAt          string             `json:"createdAt,omitempty"`  // CreatedAt - the creation date in RFC 3339 (UTC), which sorts by date in selectors unlike TimeFormat (unset for commitments created before filtering)
+  DeadlineAt         string             `json:"deadlineAt,omitempty"`  // DeadlineAt - the deadline of the next event in RFC 3339 (UTC) while the commitment is conditional or active, to select failed commitments on (see stateSelector)
+  DetachDeadline     string             `json:"detachDeadline"`     // DetachDeadline - date by which the detach event must occur
+  DischargeDeadline  string             `json:"dischargeDeadline"`  // DischargeDeadline - date by which the discharge event must occur (once detached)
+  States             []ComState         `json:"states"`             // States - event data per commitment state (created, detached, discharged)
+  Events             map[string][]map[string]interface{}  `json:"events"`  // Events - data of every accepted occurrence of each event, oldest first
+}
+
+// A change to a commitment made by a transaction, emitted in the payload of the "eventInvoke" event
+type Transition struct {
+  Spec   string  `json:"spec"`   // Spec - name of the spec of the commitment
+  ComID  string  `json:"comID"`  // ComID - the commitment ID
+  Event  string  `json:"event"`  // Event - the event (e.g. Pay), operation (cancel, release, delegate, assign) or deadline transaction (expire, violate) applied
+  State  string  `json:"state"`  // State - lifecycle state of the commitment after the change
+}
+
+// The client identity that submitted a transaction, saved alongside its writes (the key history doesn't record it)
+type Submitter struct {
+  ObjectType  string  `json:"docType"`  // docType - always "tx"
+  MSPID       string  `json:"mspID"`    // MSPID - the membership service provider of the submitter (e.g. Org1MSP)
+  Name        string  `json:"name"`     // Name - the common name of the submitter's certificate (e.g. User1@org1.example.com)
+}
+
+// A client identity, as bound to the debtor or creditor role of a commitment
+type Party struct {
+  MSPID  string  `json:"mspID"`  // MSPID - the membership service provider of the client
+  Name   string  `json:"name"`   // Name - the common name of the client's certificate
+}
+
+// A single write to one of the keys of a commitment
+type HistoryEntry struct {
+  Key        string          // Key - the event name written (or "commitment" for the commitment record)
+  TxID       string          // TxID - the transaction that made the write
+  Timestamp  string          // Timestamp - the transaction timestamp
+  MSPID      string          // MSPID - the membership service provider of the submitter
+  Submitter  string          // Submitter - the common name of the submitter
+  IsDelete   bool            // IsDelete - whether the key was deleted
+  Value      json.RawMessage // Value - the value written
+  time       time.Time
+}
+
+type QueryResponse struct {
+  Key     string                  // Key - the key for this query response
+  Record  map[string]interface{}  // Record - the record associated with this key for this query response
+}
+
+// A page of rich query results
+type QueryPage struct {
+  Records              []QueryResponse  `json:"records"`              // Records - the results on this page
+  FetchedRecordsCount  int              `json:"fetchedRecordsCount"`  // FetchedRecordsCount - the number of results on this page
+  Bookmark             string           `json:"bookmark"`             // Bookmark - passed back to obtain the next page ("" on the last page)
+}
+
+// A page of the commitments of a spec in a state
+type CommitmentPage struct {
+  Commitments          []Commitment  `json:"commitments"`          // Commitments - the commitments on this page
+  FetchedRecordsCount  int           `json:"fetchedRecordsCount"`  // FetchedRecordsCount - the number of commitments on this page
+  Bookmark             string        `json:"bookmark"`             // Bookmark - passed back to obtain the next page ("" on the last page)
+}
+
+// Narrows the commitments listed by getFilteredCommitments (empty fields match any commitment)
+type CommitmentFilter struct {
+  Debtor         string    `json:"debtor"`         // Debtor - the current debtor
+  Creditor       string    `json:"creditor"`       // Creditor - the current creditor
+  CreatedAfter   string    `json:"createdAfter"`   // CreatedAfter - created at or after this date (in TimeFormat, UTC)
+  CreatedBefore  string    `json:"createdBefore"`  // CreatedBefore - created before this date (in TimeFormat, UTC)
+  Args           []string  `json:"args"`           // Args - predicates on event arguments, all of which must hold (e.g. item=Chair, price>20, Pay.amount>=10)
+}
+
+// A CommitmentFilter checked against a spec (see parseCommitmentFilter)
+type commitmentFilter struct {
+  CommitmentFilter
+  after   time.Time
+  before  time.Time
+  args    []argPredicate
+}
+
+// An event argument of a spec, named with its event (Offer.price) or not (price, see findArg)
+type argRef struct {
+  Arg      string    // Arg - the argument name
+  Events   []string  // Events - the events declaring the argument
+  states   []int     // index of the state holding the data of each of the events
+  numeric  bool      // whether the argument is an int or decimal
+}
+
+// A predicate on an event argument, e.g. price>20
+type argPredicate struct {
+  argRef
+  Op       string    // Op - one of =, !=, >, >=, < and <=
+  Value    string    // Value - the value compared with
+  number   float64   // the value, for numeric arguments
+}
+
+// Commitment counts and timings, of every commitment of a spec or those of one agent (see getCommitmentStats)
+type Stats struct {
+  Total               int                 `json:"total"`               // Total - the number of commitments
+  Counts              map[string]int      `json:"counts"`              // Counts - the number of commitments in each current state (see currentStates)
+  Percentages         map[string]float64  `json:"percentages"`         // Percentages - the percentage of the commitments in each current state
+  AvgTimeToDetach     int64               `json:"avgTimeToDetach"`     // AvgTimeToDetach - mean seconds from creation to detachment, of the commitments detached (0 if none)
+  AvgTimeToDischarge  int64               `json:"avgTimeToDischarge"`  // AvgTimeToDischarge - mean seconds from detachment to discharge, of the commitments discharged (0 if none)
+  Detached            int                 `json:"detached"`            // Detached - the number of commitments detached, whatever their current state
+  Reputation          *float64            `json:"reputation"`          // Reputation - discharged / (discharged + violated), or detached / (detached + expired) for creditors, from 0 to 1 (null if neither)
+  discharged          int
+  timeToDetach        time.Duration
+  timeToDischarge     time.Duration
+}
+
+// The stats of the commitments of a spec, in total and per agent
+type CommitmentStats struct {
+  Spec       string             `json:"spec"`             // Spec - the spec name
+  AsOf       string             `json:"asOf"`             // AsOf - the date the commitments were evaluated as of
+  *Stats                                                  // the stats of every commitment of the spec
+  Debtors    map[string]*Stats  `json:"debtors"`          // Debtors - the stats of each debtor's commitments
+  Creditors  map[string]*Stats  `json:"creditors"`        // Creditors - the stats of each creditor's commitments
+  GroupBy    string             `json:"groupBy,omitempty"`  // GroupBy - the event argument commitments are also grouped by, if any
+  Groups     map[string]*Stats  `json:"groups,omitempty"`   // Groups - the stats of the commitments with each value of the GroupBy argument
+}
+
+// The names of the states holding the data of the create, detach and discharge events of a commitment (see ComState)
+var stateNames = []string{"Created", "Detached", "Discharged"}
+
+// The current states commitments are grouped by (see getCommitmentsByAllStates), in lifecycle order
+var currentStates = []string{StateConditional, StateActive, StateExpired, StateDischarged, StateViolated, StateCancelled, StateReleased}
+
+// The commitments obtained by each commitment listing (see COMMITMENT API METHODS), keyed by state
+var stateFilters = map[string]func(*CommitmentRecord) bool{
+  StateCreated: func(record *CommitmentRecord) bool {
+    return true
+  },
+  StateDetached: func(record *CommitmentRecord) bool {
+    return record.Transitions[StateDetached] != ""
+  },
+  StateExpired: func(record *CommitmentRecord) bool {
+    return record.State == StateExpired
+  },
+  StateDischarged: func(record *CommitmentRecord) bool {
+    return record.State == StateDischarged
+  },
+  StateViolated: func(record *CommitmentRecord) bool {
+    return record.State == StateViolated
+  },
+  StateCancelled: func(record *CommitmentRecord) bool {
+    return record.State == StateCancelled
+  },
+  StateReleased: func(record *CommitmentRecord) bool {
+    return record.State == StateReleased
+  },
+  StateConditional: func(record *CommitmentRecord) bool {
+    return record.State == StateCreated
+  },
+  StateActive: func(record *CommitmentRecord) bool {
+    return record.State == StateDetached
+  },
+}
+
+// The index commitment records are queried with, sorted by spec and commitment ID (see META-INF indexes)
+var commitmentsIndex = []string{"_design/indexCommitmentDoc", "indexCommitment"}
+
+// =========================================================================================
+// stateSelector - the selector condition on the commitment records listed in a state as of
+// a date (see stateFilters), or nil for every commitment. Records hold the state as of their
+// last event, so commitments still conditional or active are told apart from those that
+// have expired or been violated since by their deadline (deadlineAt). Records written before
+// deadlineAt was kept are selected either way, and checked once fetched.
+// =========================================================================================
+func stateSelector(state string, asOf string) (map[string]interface{}, error) {
+  date, err := time.Parse(TimeFormat, asOf)
+  if err != nil {
+    return nil, err
+  }
+  now := date.Format(time.RFC3339)
+  passed := []interface{}{
+    map[string]interface{}{"deadlineAt": map[string]interface{}{"$lte": now}},
+    map[string]interface{}{"deadlineAt": map[string]interface{}{"$exists": false}},
+  }
+  pending := []interface{}{
+    map[string]interface{}{"deadlineAt": map[string]interface{}{"$gt": now}},
+    map[string]interface{}{"deadlineAt": map[string]interface{}{"$exists": false}},
+  }
+
+  switch state {
+    case StateCreated:
+      return nil, nil
+    case StateDetached:
+      return map[string]interface{}{"transitions." + StateDetached: map[string]interface{}{"$exists": true}}, nil
+    case StateExpired:
+      return map[string]interface{}{"$or": []interface{}{
+        map[string]interface{}{"state": StateExpired},
+        map[string]interface{}{"state": StateCreated, "$or": passed},
+      }}, nil
+    case StateViolated:
+      return map[string]interface{}{"$or": []interface{}{
+        map[string]interface{}{"state": StateViolated},
+        map[string]interface{}{"state": StateDetached, "$or": passed},
+      }}, nil
+    case StateConditional:
+      return map[string]interface{}{"state": StateCreated, "$or": pending}, nil
+    case StateActive:
+      return map[string]interface{}{"state": StateDetached, "$or": pending}, nil
+  }
+  return map[string]interface{}{"state": state}, nil
+}
+
+// =============================================================================
+// Init - This function is called only once when the chaincode is instantiated.
+// Goal is to prepare the ledger to handle future requests.
+// =============================================================================
+func (t *SCC300NetworkChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+
+  // ==== Get the function and arguments from the request ==== //
+  function, _ := stub.GetFunctionAndParameters()
+
+  // ==== Check if the request is the init function ==== //
+  if function != "init" {
+    return shim.Error("Unknown function call")
+  }
+
+  // ==== Return a successful message ==== //
+  return shim.Success(nil)
+}
+
+// ============================================================
+// Invoke - All future invoke requests will arrive here
+// ============================================================
+func (t *SCC300NetworkChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+
+  // ==== Get the function and arguments from the request ==== //
+  function, args := stub.GetFunctionAndParameters()
+
+  // ==== Check whether the number of arguments is sufficient ==== //
+  if len(args) < 1 {
+    return shim.Error("The number of arguments is insufficient.")
+  }
+
+  // ==== Handle different functions ==== //
+  if function == "initSpec" {
+    return t.initSpec(stub, args)
+  } else if function == "upgradeSpec" {
+    return t.upgradeSpec(stub, args)
+  } else if function == "getSpec" {
+    return t.getSpec(stub, args)
+  } else if function == "getSpecVersions" {
+    return t.getSpecVersions(stub, args)
+  } else if function == "initCommitmentData" {
+    return t.initCommitmentData(stub, args)
+  } else if function == "richQuery" {
+    return t.richQuery(stub, args)
+  } else if function == "richQueryWithPagination" {
+    return t.richQueryWithPagination(stub, args)
+  } else if function == "getCommitmentsByAllStates" {
+    return t.getCommitmentsByAllStates(stub, args)
+  } else if function == "getCommitmentsWithPagination" {
+    return t.getCommitmentsWithPagination(stub, args)
+  } else if function == "getFilteredCommitments" {
+    return t.getFilteredCommitments(stub, args)
+  } else if function == "getCommitmentStats" {
+    return t.getCommitmentStats(stub, args)
+  } else if function == "getCreatedCommitments" {
+    return t.getCreatedCommitments(stub, args)
+  } else if function == "getDetachedCommitments" {
+    return t.getDetachedCommitments(stub, args)
+  } else if function == "getExpiredCommitments" {
+    return t.getExpiredCommitments(stub, args)
+  } else if function == "getDischargedCommitments" {
+    return t.getDischargedCommitments(stub, args)
+  } else if function == "getViolatedCommitments" {
+    return t.getViolatedCommitments(stub, args)
+  } else if function == "getCancelledCommitments" {
+    return t.getCancelledCommitments(stub, args)
+  } else if function == "getReleasedCommitments" {
+    return t.getReleasedCommitments(stub, args)
+  } else if function == "getCommitment" {
+    return t.getCommitment(stub, args)
+  } else if function == "getCommitmentHistory" {
+    return t.getCommitmentHistory(stub, args)
+  } else if function == "cancelCommitment" {
+    return t.cancelCommitment(stub, args)
+  } else if function == "releaseCommitment" {
+    return t.releaseCommitment(stub, args)
+  } else if function == "delegateCommitment" {
+    return t.delegateCommitment(stub, args)
+  } else if function == "assignCommitment" {
+    return t.assignCommitment(stub, args)
+  } else if function == "expireCommitment" {
+    return t.expireCommitment(stub, args)
+  } else if function == "violateCommitment" {
+    return t.violateCommitment(stub, args)
+  }
+
+  // ==== If the arguments given don’t match any function, we return an error ==== //
+  return shim.Error("Unknown action, check the first argument")
+}
+
+// =======================================================================
+// initSpec - create a new spec, store into chaincode state.
+// The argument list consists of the spec source code. The spec is stored
+// as version 1 (see upgradeSpec to publish later versions).
+// =======================================================================
+func (t *SCC300NetworkChaincode) initSpec(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  var err error
+
+  if len(args) != 1 {
+    return shim.Error("Incorrect number of arguments. Expecting <specSource>")
+  }
+
+  // ==== Input sanitation ==== //
+  fmt.Println("- start init spec")
+  if len(args[0]) <= 0 {
+    return shim.Error("1st argument must be a non-empty string")
+  }
+
+  // ==== Get spec source from arg list ==== //
+  source := args[0]
+
+  // ==== Compile the specification on the chaincode ==== //
+  // ==== This obtains meta info about the spec ready to initialise on CouchDB ==== //
+  spec, err := compileSpec(source)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  specName := spec.Constraint.Name
+
+  // ==== Check if spec already exists ==== //
+  specAsBytes, err := stub.GetState(specName)
+  if err != nil {
+    return shim.Error("Failed to get spec: " + err.Error())
+  } else if specAsBytes != nil {
+    fmt.Println("This spec already exists: " + specName)
+    return shim.Error("This spec already exists: " + specName)
+  }
+
+  // ==== Save spec to state ==== //
+  err = putSpec(stub, specName, source, 1)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  //  ==== Index the spec to enable range-based queries, e.g. return all SellItem commitments ==== //
+  indexName := "name"
+  ownerNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{specName})
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  //  ==== Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the commitment ==== //
+  //  ==== Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value ==== //
+  value := []byte{0x00}
+  stub.PutState(ownerNameIndexKey, value)
+
+  // ==== Spec saved and indexed. Return success ==== //
+  fmt.Println("- end init spec")
+
+  return shim.Success(nil)
+}
+
+// =======================================================================
+// upgradeSpec - publishes a new version of a registered spec, e.g. to fix
+// a deadline. Existing commitments stay on the version they were created
+// under, new commitments are created under the new version.
+// args: [specSource]
+// =======================================================================
+func (t *SCC300NetworkChaincode) upgradeSpec(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 || len(args[0]) <= 0 {
+    return shim.Error("Incorrect number of arguments. Expecting <specSource>")
+  }
+  source := args[0]
+  spec, err := compileSpec(source)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  specName := spec.Constraint.Name
+
+  // ==== Find the version being replaced ==== //
+  specAsBytes, err := stub.GetState(specName)
+  if err != nil {
+    return shim.Error("Failed to get spec: " + err.Error())
+  } else if specAsBytes == nil {
+    return refuse(StatusNotFound, "Spec does not exist: " + specName + ", register it with initSpec first")
+  }
+  latest := Spec{}
+  json.Unmarshal(specAsBytes, &latest)
+  if latest.Source == source {
+    return shim.Error(fmt.Sprintf("Spec %s is unchanged from version %d", specName, specVersion(latest.Version)))
+  }
+
+  // ==== Specs stored before versioning get their version 1 key now ==== //
+  if latest.Version == 0 {
+    if err := putSpec(stub, specName, latest.Source, 1); err != nil {
+      return shim.Error(err.Error())
+    }
+  }
+  if err := putSpec(stub, specName, source, specVersion(latest.Version) + 1); err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(nil)
+}
+
+// ========================================================
+// getSpec - read a specification from chaincode state.
+// The name can be a version key (e.g. SellItem@v1) to
+// read that version instead of the latest.
+// ========================================================
+func (t *SCC300NetworkChaincode) getSpec(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  var name, jsonResp string
+  var err error
+
+  if len(args) != 1 {
+    return shim.Error("Incorrect number of arguments. Expecting name of the spec to query")
+  }
+
+  // ==== Get the spec from chaincode state ==== //
+  name = args[0]
+  valAsbytes, err := stub.GetState(name)
+  if err != nil {
+    jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
+    return shim.Error(jsonResp)
+  } else if valAsbytes == nil {
+    jsonResp = "{\"Error\":\"Spec does not exist: " + name + "\"}"
+    return refuse(StatusNotFound, jsonResp)
+  }
+  return shim.Success(valAsbytes)
+}
+
+// =======================================================================
+// getSpecVersions - obtains every version of a spec, oldest first.
+// args: [specName]
+// =======================================================================
+func (t *SCC300NetworkChaincode) getSpecVersions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 {
+    return shim.Error("Incorrect number of arguments. Expecting name of the spec to query")
+  }
+  specName := args[0]
+
+  specAsBytes, err := stub.GetState(specName)
+  if err != nil {
+    return shim.Error("Failed to get spec: " + err.Error())
+  } else if specAsBytes == nil {
+    return refuse(StatusNotFound, "Spec does not exist: " + specName)
+  }
+  latest := Spec{}
+  json.Unmarshal(specAsBytes, &latest)
+
+  versions := []Spec{}
+  for version := 1; version <= specVersion(latest.Version); version++ {
+    com, err := getSpecVersion(stub, specName, version)
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+    versions = append(versions, *com)
+  }
+
+  versionsBytes, err := json.Marshal(versions)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(versionsBytes)
+}
+
+// ======================================================================
+// initCommitmentData - adds commitment data to blockchain to be queried.
+// Accepts an array of JSON object strings and adds to CouchDB.
+// Each event also updates the materialized record of its commitment.
+// Events are dated with the transaction timestamp, and detach and
+// discharge events carry the current debtor and creditor. Clients can't
+// supply these fields, but can declare a businessDate.
+// ======================================================================
+func (t *SCC300NetworkChaincode) initCommitmentData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  fmt.Println("- start init commitment data")
+
+  // ==== Specs are compiled once for this transaction, when an event first refers to them ==== //
+  specs := newSpecCache(stub)
+
+  // ==== Identity of the client submitting the events ==== //
+  client, err := getClient(stub)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Every event of this transaction occurs at its timestamp, agreed on by all endorsers ==== //
+  date, err := txDate(stub)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Commitment records touched by this transaction, by key (writes aren't readable until committed) ==== //
+  records := make(map[string]*CommitmentRecord)
+  recordKeys := []string{}
+  transitions := []Transition{}
+  rejected := []string{}
+  var status int32 // status - shared by every rejection (see errorStatus), shim.ERROR if they differ
+
+  // ==== Add slice data to database ==== //
+  for i, commitmentDataJSON := range args {
+
+    // ==== Obtain event name from current JSON string ==== //
+    var jsonMap map[string]string
+    if err := json.Unmarshal([]byte(commitmentDataJSON), &jsonMap); err != nil {
+      rejected = append(rejected, fmt.Sprintf("event %d: not a JSON object of string values: %s", i + 1, err.Error()))
+      status = shim.ERROR
+      continue
+    }
+    eventName := string(jsonMap["docType"])
+    comID := string(jsonMap["comID"])
+
+    // ==== Check the event against its spec and commitment, and that the client may submit it ==== //
+    record, spec, err := checkEvent(stub, specs, records, jsonMap)
+    if err == nil {
+      err = authorizeEvent(record, spec, eventName, jsonMap, client)
+    }
+    if err != nil {
+      rejected = append(rejected, fmt.Sprintf("event %d (%s of commitment %s): %s", i + 1, eventName, comID, err.Error()))
+      status = rejectionStatus(status, err)
+      continue
+    }
+
+    // ==== Stamp the event with the transaction timestamp, and detach and discharge events with the current parties ==== //
+    jsonMap["date"] = date
+    if eventName != spec.CreateEvent.Name {
+      jsonMap["debtor"], jsonMap["creditor"] = record.Debtor, record.Creditor
+    }
+
+    // ==== Save commitment to state creating a new instance with an id ==== //
+    commitmentDataJSONBytes, err := json.Marshal(jsonMap)
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+    key, err := eventKey(stub, spec.Constraint.Name, eventName, comID, stub.GetTxID(), i)
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+    err = stub.PutState(key, commitmentDataJSONBytes)
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+
+    // ==== Apply the event to its commitment record ==== //
+    recordKey, err := commitmentKey(stub, spec.Constraint.Name, comID)
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+    if _, seen := records[recordKey]; !seen {
+      recordKeys = append(recordKeys, recordKey)
+    }
+    if err := applyEvent(record, spec, eventName, jsonMap); err != nil {
+      rejected = append(rejected, fmt.Sprintf("event %d (%s of commitment %s): %s", i + 1, eventName, comID, err.Error()))
+      status = shim.ERROR
+      continue
+    }
+    records[recordKey] = record
+    transitions = append(transitions, Transition{Spec: record.Spec, ComID: comID, Event: eventName, State: record.State})
+  }
+
+  // ==== Nothing is written unless every event is accepted ==== //
+  if len(rejected) > 0 {
+    return refuse(status, fmt.Sprintf("Rejected %d of %d events:\n%s", len(rejected), len(args), strings.Join(rejected, "\n")))
+  }
+
+  // ==== Save the updated commitment records ==== //
+  for _, recordKey := range recordKeys {
+    if err := putCommitmentRecord(stub, records[recordKey]); err != nil {
+      return shim.Error(err.Error())
+    }
+  }
+
+  // ==== Record who submitted this transaction for the audit trail ==== //
+  if err := putSubmitter(stub); err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Notify listeners of the transitions made (a transaction can only set one event) ==== //
+  if err := emitTransitions(stub, transitions); err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Data saved and indexed ==== //
+  fmt.Println("- end init commitment data")
+  return shim.Success(nil)
+}
+
+// =============================== COMMITMENT OPERATIONS ========================================= //
+//
+//  cancelCommitment(stub, args): the debtor cancels a created or detached commitment.
+//    - args: slice of strings (args[0]: commitment ID, args[1]: spec name (optional))
+//  releaseCommitment(stub, args): the creditor releases the debtor from a created or detached commitment.
+//    - args: slice of strings (args[0]: commitment ID, args[1]: spec name (optional))
+//  delegateCommitment(stub, args): the debtor hands the commitment over to a new debtor.
+//    - args: slice of strings (args[0]: commitment ID, args[1]: new debtor, args[2]: spec name (optional))
+//  assignCommitment(stub, args): the creditor hands the commitment over to a new creditor.
+//    - args: slice of strings (args[0]: commitment ID, args[1]: new creditor, args[2]: spec name (optional))
+//
+//  Commitment IDs are unique within a spec. Without the spec name, the commitment is found
+//  among every registered spec, and the ID must only be used by one of them.
+//
+//  Operations take effect at the transaction timestamp.
+//  Only the client bound to the role may perform an operation, so commitments created before
+//  roles were bound can't be operated on. The new debtor or creditor is the identity of a
+//  client ([<mspID>:]<commonName>, in the submitter's MSP by default) and the role is rebound to it.
+//
+// =============================================================================================== //
+func (t *SCC300NetworkChaincode) cancelCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 && len(args) != 2 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <specName>?]")
+  }
+  return operateOnCommitment(stub, "cancel", RoleDebtor, optionalArg(args, 1), args[0], func(record *CommitmentRecord, client Party, date string) error {
+    record.State = StateCancelled
+    record.Transitions[StateCancelled] = date
+    return nil
+  })
+}
+
+func (t *SCC300NetworkChaincode) releaseCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 && len(args) != 2 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <specName>?]")
+  }
+  return operateOnCommitment(stub, "release", RoleCreditor, optionalArg(args, 1), args[0], func(record *CommitmentRecord, client Party, date string) error {
+    record.State = StateReleased
+    record.Transitions[StateReleased] = date
+    return nil
+  })
+}
+
+func (t *SCC300NetworkChaincode) delegateCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if (len(args) != 2 && len(args) != 3) || args[1] == "" {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <newDebtor>, <specName>?]")
+  }
+  return operateOnCommitment(stub, "delegate", RoleDebtor, optionalArg(args, 2), args[0], func(record *CommitmentRecord, client Party, date string) error {
+    party, err := parseParty(args[1], client.MSPID)
+    if err != nil {
+      return err
+    }
+    record.Debtor = args[1]
+    record.DebtorID = party
+    return nil
+  })
+}
+
+func (t *SCC300NetworkChaincode) assignCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if (len(args) != 2 && len(args) != 3) || args[1] == "" {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <newCreditor>, <specName>?]")
+  }
+  return operateOnCommitment(stub, "assign", RoleCreditor, optionalArg(args, 2), args[0], func(record *CommitmentRecord, client Party, date string) error {
+    party, err := parseParty(args[1], client.MSPID)
+    if err != nil {
+      return err
+    }
+    record.Creditor = args[1]
+    record.CreditorID = party
+    return nil
+  })
+}
+
+// ==========================================================================================
+// operateOnCommitment - applies an operation to the record of a commitment and saves it.
+// Only commitments that are still in progress (created or detached as of the transaction
+// timestamp) can be operated on, and only by the client bound to the given role.
+// ==========================================================================================
+func operateOnCommitment(stub shim.ChaincodeStubInterface, name string, role string, specName string, comID string, operation func(*CommitmentRecord, Party, string) error) pb.Response {
+  record, err := findCommitmentRecord(stub, specName, comID)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  if record == nil || record.State == "" {
+    return refuse(StatusNotFound, "Commitment does not exist: " + comID)
+  }
+  date, err := txDate(stub)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Commitments that have already ended can't be changed ==== //
+  if err := checkDeadlines(record, date); err != nil {
+    return shim.Error(err.Error())
+  }
+  if record.State != StateCreated && record.State != StateDetached {
+    return shim.Error("Commitment " + comID + " is " + record.State + " and can no longer be changed")
+  }
+
+  // ==== Only the party holding the role may operate on the commitment ==== //
+  client, err := getClient(stub)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  if err := authorizeRole(record, role, name, client); err != nil {
+    return errorResponse(err)
+  }
+
+  if err := operation(record, client, date); err != nil {
+    return shim.Error(err.Error())
+  }
+  if err := putCommitmentRecord(stub, record); err != nil {
+    return shim.Error(err.Error())
+  }
+  if err := putSubmitter(stub); err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Notify listeners that an event "eventInvoke" have been executed (see invoke.go) ==== //
+  err = emitTransitions(stub, []Transition{Transition{Spec: record.Spec, ComID: comID, Event: name, State: record.State}})
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(nil)
+}
+
+// =============================== DEADLINE TRANSACTIONS ========================================= //
+//
+//  expireCommitment(stub, args): records that a created commitment has expired.
+//    - args: slice of strings (args[0]: commitment ID)
+//  violateCommitment(stub, args): records that a detached commitment has been violated.
+//    - args: slice of strings (args[0]: commitment ID)
+//
+//  Commitments past a deadline are evaluated as expired or violated whenever they are queried,
+//  but nothing is written when the deadline passes. These transactions write it to the record
+//  and notify listeners (see DeadlineWatcher in the blockchain package, which submits them).
+//  Anyone may submit them: they are only accepted once the deadline has passed as of the
+//  transaction timestamp, and the transition is dated at the deadline, the moment of failure.
+//
+// =============================================================================================== //
+func (t *SCC300NetworkChaincode) expireCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 && len(args) != 2 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <specName>?]")
+  }
+  return recordFailure(stub, "expire", optionalArg(args, 1), args[0], StateCreated, StateExpired)
+}
+
+func (t *SCC300NetworkChaincode) violateCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 && len(args) != 2 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <specName>?]")
+  }
+  return recordFailure(stub, "violate", optionalArg(args, 1), args[0], StateDetached, StateViolated)
+}
+
+// ==========================================================================================
+// recordFailure - writes the time-based transition of a commitment from one state to the
+// state it fails in (see checkDeadlines) once its deadline has passed, and emits it.
+// ==========================================================================================
+func recordFailure(stub shim.ChaincodeStubInterface, name string, specName string, comID string, from string, to string) pb.Response {
+  record, err := findCommitmentRecord(stub, specName, comID)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  if record == nil || record.State == "" {
+    return refuse(StatusNotFound, "Commitment does not exist: " + comID)
+  }
+  if record.State == to {
+    return shim.Error("Commitment " + comID + " has already been recorded as " + to)
+  } else if record.State != from {
+    return shim.Error("Commitment " + comID + " is " + record.State + ", only " + from + " commitments can " + name)
+  }
+  date, err := txDate(stub)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== The deadline must have passed as of the transaction timestamp ==== //
+  if err := checkDeadlines(record, date); err != nil {
+    return shim.Error(err.Error())
+  }
+  if record.State != to {
+    deadline := record.DetachDeadline
+    if from == StateDetached {
+      deadline = record.DischargeDeadline
+    }
+    return shim.Error("Commitment " + comID + " can't " + name + " before its deadline " + deadline)
+  }
+
+  if err := putCommitmentRecord(stub, record); err != nil {
+    return shim.Error(err.Error())
+  }
+  if err := putSubmitter(stub); err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Notify listeners that an event "eventInvoke" have been executed (see invoke.go) ==== //
+  err = emitTransitions(stub, []Transition{Transition{Spec: record.Spec, ComID: comID, Event: name, State: record.State}})
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(nil)
+}
+
+// ===============================================================================================
+// getCommitment - obtains a single commitment with its full lifecycle: the event data of each
+// state, the date of each transition, its state as of the evaluation date (the transaction
+// timestamp by default) and the time left until its next deadline.
+// args: [specName ("" for any spec), comID, asOf (optional)]
+// ===============================================================================================
+func (t *SCC300NetworkChaincode) getCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 2 && len(args) != 3 {
+    return shim.Error("Incorrect number of arguments. Expecting [<specName>, <comID>, <asOf>?]")
+  }
+  specName, comID := args[0], args[1]
+  asOf, err := evaluationDate(stub, args, 2)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  record, err := findCommitmentRecord(stub, specName, comID)
+  if err != nil {
+    return shim.Error(err.Error())
+  } else if record == nil || record.State == "" {
+    return refuse(StatusNotFound, "Commitment does not exist: " + comID)
+  }
+  if err := authorizeRead(stub, record); err != nil {
+    return errorResponse(err)
+  }
+  if err := checkDeadlines(record, asOf); err != nil {
+    return shim.Error(err.Error())
+  }
+
+  commitmentBytes, err := json.Marshal(newCommitment(record, asOf))
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(commitmentBytes)
+}
+
+// ===============================================================================================
+// getCommitmentHistory - obtains every event and every write to the record of a commitment,
+// oldest first. Each entry has the transaction ID, timestamp, submitter and value written.
+// args: [comID, specName (optional)]
+// ===============================================================================================
+func (t *SCC300NetworkChaincode) getCommitmentHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 && len(args) != 2 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comID>, <specName>?]")
+  }
+  comID := args[0]
+
+  // ==== Find the events of the spec this commitment is an instance of ==== //
+  record, err := findCommitmentRecord(stub, optionalArg(args, 1), comID)
+  if err != nil {
+    return shim.Error(err.Error())
+  } else if record == nil || record.State == "" {
+    return refuse(StatusNotFound, "Commitment does not exist: " + comID)
+  }
+  if err := authorizeRead(stub, record); err != nil {
+    return errorResponse(err)
+  }
+  spec, err := getCompiledSpecVersion(stub, record.Spec, record.SpecVersion)
+  if err != nil {
+    return shim.Error("Failed to get spec " + record.Spec + " of commitment " + comID + ": " + err.Error())
+  }
+
+  // ==== Collect the history of each key ==== //
+  recordKey, err := commitmentKey(stub, record.Spec, comID)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  history, err := getKeyHistory(stub, "commitment", recordKey)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  for _, event := range []*q.Event{spec.CreateEvent, spec.DetachEvent, spec.DischargeEvent} {
+    keys, err := eventKeys(stub, spec.Constraint.Name, event.Name, comID)
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+    for _, key := range keys {
+      entries, err := getKeyHistory(stub, event.Name, key)
+      if err != nil {
+        return shim.Error(err.Error())
+      }
+      history = append(history, entries...)
+    }
+  }
+  sort.SliceStable(history, func(i, j int) bool {
+    return history[i].time.Before(history[j].time)
+  })
+
+  historyBytes, err := json.Marshal(history)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(historyBytes)
+}
+
+// ==========================================================================================
+// getKeyHistory - obtains the writes to a single key along with who submitted them.
+// ==========================================================================================
+func getKeyHistory(stub shim.ChaincodeStubInterface, name string, key string) ([]HistoryEntry, error) {
+  resultsIterator, err := stub.GetHistoryForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  defer resultsIterator.Close()
+
+  entries := []HistoryEntry{}
+  for resultsIterator.HasNext() {
+    modification, err := resultsIterator.Next()
+    if err != nil {
+      return nil, err
+    }
+    entry := HistoryEntry{Key: name, TxID: modification.TxId, IsDelete: modification.IsDelete}
+    if modification.Timestamp != nil {
+      entry.time = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+      entry.Timestamp = entry.time.Format(TimeFormat)
+    }
+    if !modification.IsDelete && len(modification.Value) > 0 {
+      entry.Value = json.RawMessage(modification.Value)
+    }
+    submitter, err := getSubmitter(stub, modification.TxId)
+    if err != nil {
+      return nil, err
+    }
+    if submitter != nil {
+      entry.MSPID = submitter.MSPID
+      entry.Submitter = submitter.Name
+    }
+    entries = append(entries, entry)
+  }
+  return entries, nil
+}
+
+// =============================== COMMITMENT API METHODS ======================================== //
+//
+//  getCreatedCommitments(stub, args): obtains all created commitments by commitment name.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: as of date (optional))
+//  getDetachedCommitments(stub, args): obtains all detached commitments by commitment name.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: false, args[2]: as of date (optional))
+//  getDischargedCommitments(stub, args): obtains all discharged commitments by commitment name.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: false, args[2]: as of date (optional))
+//  getExpiredCommitments(stub, args): obtains all expired commitments by commitment name.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: true (boolean flag - true to
+//            get expired, false to get detached - this prevents repetition of logic),
+//            args[2]: as of date (optional))
+//  getViolatedCommitments(stub, args): obtains all violated commitments by commitment name.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: true (boolean flag - true to
+//            get violated, false to get discharged - this prevents repetition of logic),
+//            args[2]: as of date (optional))
+//  getCancelledCommitments(stub, args): obtains all commitments cancelled by their debtor.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: as of date (optional))
+//  getReleasedCommitments(stub, args): obtains all commitments released by their creditor.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: as of date (optional))
+//  getCommitmentsByAllStates(stub, args): obtains all commitments grouped by current state
+//    (conditional, active, expired, discharged, violated, cancelled or released).
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: as of date (optional))
+//  getCommitment(stub, args): obtains a single commitment with its full lifecycle.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name ("" for any), args[1]: commitment ID,
+//            args[2]: as of date (optional))
+//  getCommitmentsWithPagination(stub, args): obtains a page of the commitments in a state.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: state (created, detached,
+//            expired, discharged, violated, cancelled, released, conditional or active),
+//            args[2]: page size, args[3]: bookmark ("" for the first page), args[4]: as of
+//            date (optional))
+//  getFilteredCommitments(stub, args): obtains a page of the commitments in a state matching
+//    a filter on their debtor, creditor, creation date and event arguments.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: state (as above, "" for any),
+//            args[2]: filter (a CommitmentFilter as JSON), args[3]: page size, args[4]: bookmark
+//            ("" for the first page), args[5]: as of date (optional))
+//  getCommitmentStats(stub, args): obtains the counts, percentages and average times of the
+//    commitments by current state, in total and per debtor and creditor with their reputation.
+//    - stub: required chaincode interface
+//    - args: slice of strings (args[0]: commitment name, args[1]: event argument to also group
+//            by (optional, e.g. Delivery.courier), args[2]: as of date (optional))
+//
+//  Clients only read the commitments they are the debtor or creditor of: the others are left out
+//  of lists and stats, and refused with StatusForbidden when asked for by ID (see authorizeRead).
+//
+//  Commitments are evaluated as of the given date (in TimeFormat, UTC), or as of the timestamp
+//  of the query transaction if none is given, never the peer's clock.
+//
+// =============================================================================================== //
+
+// =========================== COMMITMENT LIFECYCLE ENGINE =======================================
+//  Obtains the commitment records of a given spec in a state with one indexed query. Records are
+//  kept up to date on every accepted event (see applyEvent), so only the time-based transitions
+//  (expiry and violation) need checking as of the evaluation date. The state is selected on along
+//  with the selector given, which narrows the records fetched (nil fetches every commitment of the
+//  spec, see commitmentFilter.selector), and so are the parties: only the records the client may
+//  read are fetched (see partySelector). Every record fetched is then checked against the
+//  state and keep (if any) as of the evaluation date. With a page size, obtains one page of them
+//  along with the bookmark of the next page (see queryPage).
+// ===============================================================================================
+func (t *SCC300NetworkChaincode) evaluateCommitments(stub shim.ChaincodeStubInterface, comName string, selector map[string]interface{}, state string, asOf string, keep func(*CommitmentRecord) bool, pageSize int, bookmark string) ([]*CommitmentRecord, string, error) {
+
+  // ==== Input sanitation ==== //
+  if len(comName) <= 0 {
+    return nil, "", errors.New("1st argument must be a non-empty string")
+  }
+
+  // ==== Check the spec exists ==== //
+  response := t.getSpec(stub, []string{comName})
+  if response.Status != shim.OK {
+    return nil, "", errors.New(response.Message)
+  }
+
+  // ==== Select the commitment records of this spec in the state ==== //
+  inState, ok := stateFilters[state]
+  if !ok {
+    return nil, "", errors.New("Unknown commitment state " + state)
+  }
+  if selector == nil {
+    selector = map[string]interface{}{"docType": "commitment", "spec": comName}
+  }
+  condition, err := stateSelector(state, asOf)
+  if err != nil {
+    return nil, "", err
+  }
+  conditions, _ := selector["$and"].([]interface{})
+  if condition != nil {
+    conditions = append(conditions, condition)
+  }
+
+  // ==== Of those, only the commitments the client is a party to ==== //
+  client, err := getClient(stub)
+  if err != nil {
+    return nil, "", err
+  }
+  selector["$and"] = append(conditions, partySelector(client))
+  query := pagedQuery{Selector: selector, Sort: []string{"docType", "spec", "comID"}, Index: commitmentsIndex}
+
+  // ==== Fetch them, applying time-based transitions as of the evaluation date ==== //
+  results, next, err := queryPage(stub, query, pageSize, bookmark, func(queryResponse *queryresult.KV) (interface{}, error) {
+    record := &CommitmentRecord{}
+    if err := json.Unmarshal(queryResponse.Value, record); err != nil {
+      return nil, err
+    }
+    if err := checkDeadlines(record, asOf); err != nil {
+      return nil, err
+    }
+    if !inState(record) || (keep != nil && !keep(record)) {
+      return nil, nil
+    }
+    return record, nil
+  })
+  if err != nil {
+    return nil, "", err
+  }
+  records := []*CommitmentRecord{}
+  for _, result := range results {
+    records = append(records, result.(*CommitmentRecord))
+  }
+  return records, next, nil
+}
+
+// =============================================================================================
+// applyEvent - applies an accepted event to a commitment record using the compiled spec.
+// A commitment is detached if the detach event occurs before the detach deadline, otherwise it
+// expires. Only detached commitments can be discharged, and a detached commitment is violated
+// if the discharge event occurs after the discharge deadline, either transition dated at the
+// deadline missed rather than when the late event arrives. Detach and discharge events can
+// occur several times (e.g. payment in instalments): each occurrence is kept, and the transition
+// happens once the guard (where clause) holds, which can aggregate over them (sum(Pay.amount)).
+// An event whose guard can't be evaluated is rejected.
+// =============================================================================================
+func applyEvent(record *CommitmentRecord, spec *q.Spec, eventName string, event map[string]string) error {
+  data := make(map[string]interface{})
+  for key, value := range event {
+    data[key] = value
+  }
+  date := event["date"]
+
+  // ==== Keep every occurrence of the event ==== //
+  if eventName == spec.CreateEvent.Name || record.Events == nil {
+    record.Events = make(map[string][]map[string]interface{})
+  }
+  record.Events[eventName] = append(record.Events[eventName], data)
+
+  switch eventName {
+    case spec.CreateEvent.Name:
+      record.Spec = spec.Constraint.Name
+      record.State = StateCreated
+      record.Debtor = event["debtor"]
+      record.Creditor = event["creditor"]
+      record.Transitions = map[string]string{StateCreated: date}
+      record.CreatedAt = ""
+      if created, err := time.Parse(TimeFormat, date); err == nil {
+        record.CreatedAt = created.Format(time.RFC3339)
+      }
+      record.DischargeDeadline = ""
+      record.States = []ComState {
+        ComState{Name: stateNames[0], Data: data},
+        ComState{Name: stateNames[1], Data: nil},
+        ComState{Name: stateNames[2], Data: nil},
+      }
+      deadline, err := computeDeadline(spec.DetachEvent, spec, record)
+      if err != nil {
+        return err
+      }
+      record.DetachDeadline = deadline
+    case spec.DetachEvent.Name:
+      if record.State != StateCreated {
+        return nil
+      }
+      if holds, err := guardHolds(spec.DetachEvent, record, spec); err != nil || !holds {
+        return err
+      }
+      record.States[1].Data = data
+      before, err := isBeforeDeadline(date, record.DetachDeadline)
+      if err != nil {
+        return err
+      }
+      if before {
+        record.State = StateDetached
+        record.Transitions[StateDetached] = date
+        deadline, err := computeDeadline(spec.DischargeEvent, spec, record)
+        if err != nil {
+          return err
+        }
+        record.DischargeDeadline = deadline
+      } else {
+        // ==== Too late: the commitment expired at its deadline, as checkDeadlines records it ==== //
+        record.State = StateExpired
+        record.Transitions[StateExpired] = record.DetachDeadline
+      }
+    case spec.DischargeEvent.Name:
+      if record.State != StateDetached {
+        return nil
+      }
+      if holds, err := guardHolds(spec.DischargeEvent, record, spec); err != nil || !holds {
+        return err
+      }
+      record.States[2].Data = data
+      before, err := isBeforeDeadline(date, record.DischargeDeadline)
+      if err != nil {
+        return err
+      }
+      if before {
+        record.State = StateDischarged
+        record.Transitions[StateDischarged] = date
+      } else {
+        record.State = StateViolated
+        record.Transitions[StateViolated] = record.DischargeDeadline
+      }
+  }
+  return nil
+}
+
+// ==========================================================================================
+// computeDeadline - obtains the deadline date of a detach or discharge event. Deadlines are
+// measured from their anchor event (deadline=5 after create/detach), or read from a field
+// of an earlier event for absolute deadlines (deadline by Offer.expiry).
+// ==========================================================================================
+func computeDeadline(event *q.Event, spec *q.Spec, record *CommitmentRecord) (string, error) {
+  if event.By != nil {
+    val, err := event.By.Eval(eventEnv(record, spec))
+    if err != nil {
+      return "", fmt.Errorf("Failed to get %s deadline: %s", event.Name, err.Error())
+    }
+    date, _ := val.(string)
+    if _, err := time.Parse(TimeFormat, date); err != nil {
+      return "", fmt.Errorf("Invalid %s deadline %s=%v, expected a date like %q", event.Name, event.By, val, TimeFormat)
+    }
+    return date, nil
+  }
+
+  anchor := record.Transitions[StateCreated]
+  if event.Anchor == q.AfterDetach {
+    anchor = record.Transitions[StateDetached]
+  }
+  deadline, err := addDeadline(anchor, event.Deadline)
+  if err != nil {
+    return "", fmt.Errorf("Failed to get %s deadline: %s", event.Name, err.Error())
+  }
+  return deadline, nil
+}
+
+// ==========================================================================================
+// guardHolds - evaluates the guard of a detach or discharge event against the events of
+// this commitment that have occurred, including the incoming one. A guard that can't be
+// evaluated (e.g. comparing a field missing from the event) is an error, not false.
+// ==========================================================================================
+func guardHolds(event *q.Event, record *CommitmentRecord, spec *q.Spec) (bool, error) {
+  holds, err := q.EvalGuard(event.Guard, eventEnv(record, spec))
+  if err != nil {
+    return false, fmt.Errorf("Failed to evaluate the guard on %s: %s", event.Name, err.Error())
+  }
+  return holds, nil
+}
+
+// ==========================================================================================
+// eventEnv - the events of a commitment that have occurred, to evaluate guards and deadlines
+// against. Records made before every occurrence was kept only hold the events that caused
+// a transition.
+// ==========================================================================================
+func eventEnv(record *CommitmentRecord, spec *q.Spec) q.Env {
+  env := q.Env{}
+  for name, occurrences := range record.Events {
+    env[name] = occurrences
+  }
+  for i, event := range []*q.Event{spec.CreateEvent, spec.DetachEvent, spec.DischargeEvent} {
+    if len(env[event.Name]) == 0 && i < len(record.States) && record.States[i].Data != nil {
+      env[event.Name] = []map[string]interface{}{record.States[i].Data}
+    }
+  }
+  return env
+}
+
+// ====================================================================================
+// currentState - the one state a commitment is in. Commitments in progress are
+// conditional (created, waiting for the detach event) or active (detached, waiting
+// for the discharge event); the others are in the state they ended in.
+// ====================================================================================
+func currentState(record *CommitmentRecord) string {
+  switch record.State {
+    case StateCreated:
+      return StateConditional
+    case StateDetached:
+      return StateActive
+  }
+  return record.State
+}
+
+// ====================================================================================
+// checkDeadlines - applies the time-based transitions to a commitment record.
+// Created commitments past their detach deadline have expired, and detached
+// commitments past their discharge deadline have been violated. A deadline
+// that can't be parsed is an error, not a commitment that has failed.
+// ====================================================================================
+func checkDeadlines(record *CommitmentRecord, now string) error {
+  var deadline, failed string
+  switch record.State {
+    case StateCreated:
+      deadline, failed = record.DetachDeadline, StateExpired
+    case StateDetached:
+      deadline, failed = record.DischargeDeadline, StateViolated
+    default:
+      return nil
+  }
+  before, err := isBeforeDeadline(now, deadline)
+  if err != nil {
+    return fmt.Errorf("Failed to check the deadline of commitment %s: %s", record.ComID, err.Error())
+  }
+  if !before {
+    record.State = failed
+    record.Transitions[failed] = deadline
+  }
+  return nil
+}
+
+// ==========================================================================
+// filterCommitments - obtains the commitments of a spec in the given state
+// (see stateFilters) as of a date and returns them to the requester. The
+// date is the optional argument at asOfArg.
+// ==========================================================================
+func (t *SCC300NetworkChaincode) filterCommitments(stub shim.ChaincodeStubInterface, args []string, asOfArg int, state string) pb.Response {
+  if len(args) < 1 || len(args) > asOfArg + 1 {
+    return shim.Error("Incorrect number of arguments")
+  }
+  asOf, err := evaluationDate(stub, args, asOfArg)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  records, _, err := t.evaluateCommitments(stub, args[0], nil, state, asOf, nil, 0, "")
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  commitments := []Commitment{}
+  for _, record := range records {
+    commitments = append(commitments, newCommitment(record, asOf))
+  }
+
+  // ==== Convert commitments to bytes to send to requester ==== //
+  commitmentsBytes, _ := commitmentsToBytes(commitments)
+  return shim.Success(commitmentsBytes)
+}
+
+// =========================== GET CREATED COMMITMENTS ========================
+//  Obtains all created commitments based on a given commitment name.
+//  A commitment is created if it exists on the blockchain CouchDB database.
+// ============================================================================
+func (t *SCC300NetworkChaincode) getCreatedCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  return t.filterCommitments(stub, args, 1, StateCreated)
+}
+
+// =========================== GET DETACHED COMMITMENTS ======================================
+//  Obtains all detached commitments based on a given commitment/spec name.
+//  A commitment is detached if the created event exists on the blockchain CouchDB database
+//  and the detached event has occured within the specified deadline.
+//  If the commitment isn't detached and the deadline has exceeded, the commitment expires.
+// ===========================================================================================
+func (t *SCC300NetworkChaincode) getDetachedCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  // ==== Extract args ==== //
+  if len(args) != 2 && len(args) != 3 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comName>, <wantExpired>, <asOf>?]")
+  }
+  wantExpired, _ := strconv.ParseBool(args[1])
+
+  if wantExpired {
+    return t.filterCommitments(stub, args, 2, StateExpired)
+  }
+  return t.filterCommitments(stub, args, 2, StateDetached)
+}
+
+// =========================== GET DISCHARGED COMMITMENTS =======================
+//  Obtains all discharged commitments based on a given commitment/spec name.
+// ==============================================================================
+func (t *SCC300NetworkChaincode) getDischargedCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  // ==== Extract args ==== //
+  if len(args) != 2 && len(args) != 3 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comName>, <wantViolated>, <asOf>?]")
+  }
+  wantViolated, _ := strconv.ParseBool(args[1])
+
+  if wantViolated {
+    return t.filterCommitments(stub, args, 2, StateViolated)
+  }
+  return t.filterCommitments(stub, args, 2, StateDischarged)
+}
+
+// =========================== GET EXPIRED COMMITMENTS ======================
+//  Obtains all expired commitments based on a given commitment/spec name.
+//  This method simply calls getDetachedCommitments() with an extra
+//  boolean flag of 'true' to obtain all the failed detached commitments.
+// ==========================================================================
+func (t *SCC300NetworkChaincode) getExpiredCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  return t.getDetachedCommitments(stub, args)
+}
+
+// =========================== GET VIOLATED COMMITMENTS ======================
+//  Obtains all violated commitments based on a given commitment/spec name.
+//  This method simply calls getDischargedCommitments() with an extra
+//  boolean flag of 'true' to obtain all the failed discharged commitments.
+// ===========================================================================
+func (t *SCC300NetworkChaincode) getViolatedCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  return t.getDischargedCommitments(stub, args)
+}
+
+// =========================== GET CANCELLED COMMITMENTS ======================
+//  Obtains all commitments of a given spec that were cancelled by their debtor.
+// ============================================================================
+func (t *SCC300NetworkChaincode) getCancelledCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  return t.filterCommitments(stub, args, 1, StateCancelled)
+}
+
+// =========================== GET RELEASED COMMITMENTS =======================
+//  Obtains all commitments of a given spec that were released by their creditor.
+// ============================================================================
+func (t *SCC300NetworkChaincode) getReleasedCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  return t.filterCommitments(stub, args, 1, StateReleased)
+}
+
+// =========================== GET COMMITMENTS BY ALL STATES =====================
+//  Obtains every commitment of a given spec grouped by its current state (see
+//  currentState), so each commitment is listed under exactly one state and
+//  every state is listed, if only with no commitments.
+// ===============================================================================
+func (t *SCC300NetworkChaincode) getCommitmentsByAllStates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 1 && len(args) != 2 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comName>, <asOf>?]")
+  }
+  asOf, err := evaluationDate(stub, args, 1)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  records, _, err := t.evaluateCommitments(stub, args[0], nil, StateCreated, asOf, nil, 0, "")
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Group the commitments by current state ==== //
+  byState := make(map[string][]Commitment)
+  for _, state := range currentStates {
+    byState[state] = []Commitment{}
+  }
+  for _, record := range records {
+    state := currentState(record)
+    byState[state] = append(byState[state], newCommitment(record, asOf))
+  }
+
+  byStateBytes, err := json.Marshal(byState)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(byStateBytes)
+}
+
+// =========================== GET COMMITMENTS WITH PAGINATION ===================
+//  Obtains a page of the commitments of a given spec in a given state, so busy
+//  specs can be listed a page at a time.
+// ===============================================================================
+func (t *SCC300NetworkChaincode) getCommitmentsWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  // ==== Extract args ==== //
+  if len(args) != 4 && len(args) != 5 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comName>, <state>, <pageSize>, <bookmark>, <asOf>?]")
+  }
+  pageSize, err := strconv.Atoi(args[2])
+  if err != nil || pageSize <= 0 {
+    return shim.Error("Page size must be a positive integer, got " + args[2])
+  }
+  asOf, err := evaluationDate(stub, args, 4)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  records, bookmark, err := t.evaluateCommitments(stub, args[0], nil, args[1], asOf, nil, pageSize, args[3])
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  page := CommitmentPage{Commitments: []Commitment{}, FetchedRecordsCount: len(records), Bookmark: bookmark}
+  for _, record := range records {
+    page.Commitments = append(page.Commitments, newCommitment(record, asOf))
+  }
+
+  pageBytes, err := json.Marshal(page)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(pageBytes)
+}
+
+// =========================== GET FILTERED COMMITMENTS ==========================
+//  Obtains a page of the commitments of a given spec in a given state ("" for
+//  any) that match a filter on their parties, creation date and event data, e.g.
+//  all of Harry's violated orders this month. The filter is compiled into a
+//  CouchDB selector (see commitmentFilter.selector).
+// ===============================================================================
+func (t *SCC300NetworkChaincode) getFilteredCommitments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  // ==== Extract args ==== //
+  if len(args) != 5 && len(args) != 6 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comName>, <state>, <filter>, <pageSize>, <bookmark>, <asOf>?]")
+  }
+  state := args[1]
+  if state == "" {
+    state = StateCreated
+  }
+  pageSize, err := strconv.Atoi(args[3])
+  if err != nil || pageSize <= 0 {
+    return shim.Error("Page size must be a positive integer, got " + args[3])
+  }
+  asOf, err := evaluationDate(stub, args, 5)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Compile the filter against the latest version of the spec ==== //
+  spec, err := getCompiledSpec(stub, args[0])
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  filter, err := parseCommitmentFilter(args[2], spec)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  records, bookmark, err := t.evaluateCommitments(stub, args[0], filter.selector(args[0]), state, asOf, filter.matches, pageSize, args[4])
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  page := CommitmentPage{Commitments: []Commitment{}, FetchedRecordsCount: len(records), Bookmark: bookmark}
+  for _, record := range records {
+    page.Commitments = append(page.Commitments, newCommitment(record, asOf))
+  }
+
+  pageBytes, err := json.Marshal(page)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(pageBytes)
+}
+
+// ==========================================================================================
+// parseCommitmentFilter - reads a CommitmentFilter and checks its argument predicates against
+// the events of a spec (see findArg).
+// ==========================================================================================
+func parseCommitmentFilter(filterJSON string, spec *q.Spec) (*commitmentFilter, error) {
+  filter := &commitmentFilter{}
+  if filterJSON != "" {
+    if err := json.Unmarshal([]byte(filterJSON), &filter.CommitmentFilter); err != nil {
+      return nil, fmt.Errorf("Invalid filter: %s", err.Error())
+    }
+  }
+  for i, date := range []string{filter.CreatedAfter, filter.CreatedBefore} {
+    if date == "" {
+      continue
+    }
+    parsed, err := time.Parse(TimeFormat, date)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid filter: creation date %q, expected a date like %q", date, TimeFormat)
+    }
+    if i == 0 {
+      filter.after = parsed
+    } else {
+      filter.before = parsed
+    }
+  }
+
+  for _, predicate := range filter.Args {
+    arg, err := parseArgPredicate(predicate, spec)
+    if err != nil {
+      return nil, err
+    }
+    filter.args = append(filter.args, arg)
+  }
+  return filter, nil
+}
+
+// ==========================================================================================
+// parseArgPredicate - reads a predicate on an event argument, made of the argument name, one
+// of the operators =, !=, >, >=, < and <=, and a value (e.g. item=Chair or price>20).
+// Arguments declared as int or decimal are compared as numbers, the others as strings.
+// ==========================================================================================
+func parseArgPredicate(predicate string, spec *q.Spec) (argPredicate, error) {
+  pred := argPredicate{}
+  i := strings.IndexAny(predicate, "!=<>")
+  if i <= 0 {
+    return pred, fmt.Errorf("Invalid filter %q, expected an argument, operator and value (e.g. item=Chair or price>20)", predicate)
+  }
+  op := predicate[i:i+1]
+  if i + 1 < len(predicate) && predicate[i+1] == '=' {
+    op = predicate[i:i+2]
+  }
+  if op == "!" {
+    return pred, fmt.Errorf("Invalid filter %q, expected an argument, operator and value (e.g. item=Chair or price>20)", predicate)
+  }
+  name := strings.TrimSpace(predicate[:i])
+  pred.Value = strings.TrimSpace(predicate[i+len(op):])
+  pred.Op = op
+  if op == "==" {
+    pred.Op = "="
+  }
+
+  ref, err := findArg(name, spec)
+  if err != nil {
+    return pred, fmt.Errorf("Invalid filter %q: %s", predicate, err.Error())
+  }
+  pred.argRef = ref
+  if pred.numeric {
+    value, err := strconv.ParseFloat(pred.Value, 64)
+    if err != nil {
+      return pred, fmt.Errorf("Invalid filter %q: %s is a number, found %q", predicate, name, pred.Value)
+    }
+    pred.number = value
+  }
+  return pred, nil
+}
+
+// ==========================================================================================
+// findArg - finds the events of a spec declaring an argument. Argument names may be
+// qualified with their event (Offer.price) or not (price), in which case they refer to the
+// argument of any event declaring it.
+// ==========================================================================================
+func findArg(name string, spec *q.Spec) (argRef, error) {
+  ref := argRef{Arg: name}
+  eventName := ""
+  if dot := strings.Index(name, "."); dot >= 0 {
+    eventName, ref.Arg = name[:dot], name[dot+1:]
+  }
+  for i, event := range []*q.Event{spec.CreateEvent, spec.DetachEvent, spec.DischargeEvent} {
+    if eventName != "" && event.Name != eventName {
+      continue
+    }
+    for _, arg := range event.Args {
+      if arg.Name != ref.Arg {
+        continue
+      }
+      ref.Events = append(ref.Events, event.Name)
+      ref.states = append(ref.states, i)
+      if arg.Type == q.TypeInt || arg.Type == q.TypeDecimal {
+        ref.numeric = true
+      }
+    }
+  }
+  if len(ref.Events) == 0 {
+    return ref, fmt.Errorf("%s has no argument %s", spec.Constraint.Name, name)
+  }
+  return ref, nil
+}
+
+// ==========================================================================================
+// values - the values of every occurrence of an argument in a commitment, oldest first,
+// followed by those in the state data of records that don't keep every occurrence (or of the
+// occurrence that caused the transition, which then comes last).
+// ==========================================================================================
+func (ref argRef) values(record *CommitmentRecord) []interface{} {
+  values := []interface{}{}
+  for _, eventName := range ref.Events {
+    for _, data := range record.Events[eventName] {
+      if value, ok := data[ref.Arg]; ok {
+        values = append(values, value)
+      }
+    }
+  }
+  for _, i := range ref.states {
+    if i >= len(record.States) {
+      continue
+    }
+    if value, ok := record.States[i].Data[ref.Arg]; ok {
+      values = append(values, value)
+    }
+  }
+  return values
+}
+
+// ==========================================================================================
+// selector - compiles the filter into a CouchDB selector for the commitment records of a spec.
+// Event data is stored as strings and dates in TimeFormat, which CouchDB can't compare as
+// numbers or dates, so the selector only narrows the records down: parties, exact argument
+// values and creation dates (createdAt) are selected on, while numeric comparisons only
+// select the records where the argument occurs. Every record fetched is then checked
+// against the filter itself (see matches). Records written before createdAt and per-event
+// data was kept are selected on their creation transition and state data instead.
+// ==========================================================================================
+func (filter *commitmentFilter) selector(comName string) map[string]interface{} {
+  selector := map[string]interface{}{"docType": "commitment", "spec": comName}
+  if filter.Debtor != "" {
+    selector["debtor"] = filter.Debtor
+  }
+  if filter.Creditor != "" {
+    selector["creditor"] = filter.Creditor
+  }
+
+  conditions := []interface{}{}
+  if !filter.after.IsZero() || !filter.before.IsZero() {
+    createdAt := map[string]interface{}{}
+    if !filter.after.IsZero() {
+      createdAt["$gte"] = filter.after.Format(time.RFC3339)
+    }
+    if !filter.before.IsZero() {
+      createdAt["$lt"] = filter.before.Format(time.RFC3339)
+    }
+    conditions = append(conditions, map[string]interface{}{"$or": []interface{}{
+      map[string]interface{}{"createdAt": createdAt},
+      map[string]interface{}{"createdAt": map[string]interface{}{"$exists": false}},
+    }})
+  }
+  for _, pred := range filter.args {
+    var value interface{} = map[string]interface{}{"$exists": true}
+    if pred.Op == "=" && !pred.numeric {
+      value = pred.Value
+    }
+    occurs := []interface{}{}
+    for _, eventName := range pred.Events {
+      occurs = append(occurs, map[string]interface{}{"events." + eventName: map[string]interface{}{"$elemMatch": map[string]interface{}{pred.Arg: value}}})
+    }
+    for _, i := range pred.states {
+      occurs = append(occurs, map[string]interface{}{"states": map[string]interface{}{"$elemMatch": map[string]interface{}{"Name": stateNames[i], "Data." + pred.Arg: value}}})
+    }
+    conditions = append(conditions, map[string]interface{}{"$or": occurs})
+  }
+  if len(conditions) > 0 {
+    selector["$and"] = conditions
+  }
+  return selector
+}
+
+// ==========================================================================================
+// matches - checks a commitment record against the filter. An argument predicate holds if
+// any occurrence of the argument satisfies it.
+// ==========================================================================================
+func (filter *commitmentFilter) matches(record *CommitmentRecord) bool {
+  if filter.Debtor != "" && record.Debtor != filter.Debtor {
+    return false
+  }
+  if filter.Creditor != "" && record.Creditor != filter.Creditor {
+    return false
+  }
+  if !filter.after.IsZero() || !filter.before.IsZero() {
+    created, err := time.Parse(TimeFormat, record.Transitions[StateCreated])
+    if err != nil || created.Before(filter.after) || (!filter.before.IsZero() && !created.Before(filter.before)) {
+      return false
+    }
+  }
+  for _, pred := range filter.args {
+    if !pred.holds(record) {
+      return false
+    }
+  }
+  return true
+}
+
+// ==========================================================================================
+// holds - checks an argument predicate against every occurrence of the argument.
+// ==========================================================================================
+func (pred argPredicate) holds(record *CommitmentRecord) bool {
+  for _, value := range pred.values(record) {
+    str := fmt.Sprint(value)
+    var cmp int
+    if pred.numeric {
+      number, err := strconv.ParseFloat(str, 64)
+      if err != nil {
+        continue
+      }
+      if number < pred.number {
+        cmp = -1
+      } else if number > pred.number {
+        cmp = 1
+      }
+    } else {
+      cmp = strings.Compare(str, pred.Value)
+    }
+    if pred.satisfied(cmp) {
+      return true
+    }
+  }
+  return false
+}
+
+// Whether the result of comparing a value with the predicate value satisfies its operator
+func (pred argPredicate) satisfied(cmp int) bool {
+  switch pred.Op {
+    case "!=":
+      return cmp != 0
+    case ">":
+      return cmp > 0
+    case ">=":
+      return cmp >= 0
+    case "<":
+      return cmp < 0
+    case "<=":
+      return cmp <= 0
+  }
+  return cmp == 0
+}
+
+// =========================== GET COMMITMENT STATS ===============================
+//  Obtains the number and percentage of the commitments of a given spec in each
+//  current state (see currentState) with the average times to detach and to
+//  discharge them, in total and per debtor and creditor, with the reputation of
+//  each on their own obligation (see Stats.finish). Optionally also per value of an event argument (e.g. Delivery.courier),
+//  using its latest occurrence, to rank e.g. couriers.
+// ================================================================================
+func (t *SCC300NetworkChaincode) getCommitmentStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) < 1 || len(args) > 3 {
+    return shim.Error("Incorrect number of arguments. Expecting [<comName>, <groupBy>?, <asOf>?]")
+  }
+  asOf, err := evaluationDate(stub, args, 2)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  stats := CommitmentStats{Spec: args[0], AsOf: asOf, Stats: newStats(), Debtors: map[string]*Stats{}, Creditors: map[string]*Stats{}}
+
+  var groupBy argRef
+  if len(args) > 1 && args[1] != "" {
+    spec, err := getCompiledSpec(stub, args[0])
+    if err != nil {
+      return shim.Error(err.Error())
+    }
+    if groupBy, err = findArg(args[1], spec); err != nil {
+      return shim.Error("Invalid groupBy: " + err.Error())
+    }
+    stats.GroupBy = args[1]
+    stats.Groups = map[string]*Stats{}
+  }
+
+  records, _, err := t.evaluateCommitments(stub, args[0], nil, StateCreated, asOf, nil, 0, "")
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+
+  // ==== Count each commitment in total, for its debtor and creditor and for its argument value ==== //
+  groups := []map[string]*Stats{stats.Debtors, stats.Creditors, stats.Groups}
+  for _, record := range records {
+    keys := []string{record.Debtor, record.Creditor, ""}
+    if values := groupBy.values(record); len(values) > 0 {
+      keys[2] = fmt.Sprint(values[len(values) - 1])
+    }
+    stats.add(record)
+    for i, key := range keys {
+      if key == "" || groups[i] == nil {
+        continue
+      }
+      if groups[i][key] == nil {
+        groups[i][key] = newStats()
+      }
+      groups[i][key].add(record)
+    }
+  }
+  stats.finish(false)
+  for i, group := range groups {
+    for _, agentStats := range group {
+      agentStats.finish(i == 1)
+    }
+  }
+
+  statsBytes, err := json.Marshal(stats)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(statsBytes)
+}
+
+// ==========================================================================================
+// newStats - creates empty stats with every current state counted.
+// ==========================================================================================
+func newStats() *Stats {
+  stats := &Stats{Counts: map[string]int{}, Percentages: map[string]float64{}}
+  for _, state := range currentStates {
+    stats.Counts[state] = 0
+    stats.Percentages[state] = 0
+  }
+  return stats
+}
+
+// ==========================================================================================
+// add - counts a commitment (with its time-based transitions applied) in the stats.
+// ==========================================================================================
+func (stats *Stats) add(record *CommitmentRecord) {
+  stats.Total++
+  stats.Counts[currentState(record)]++
+
+  created, err := time.Parse(TimeFormat, record.Transitions[StateCreated])
+  detached, detachErr := time.Parse(TimeFormat, record.Transitions[StateDetached])
+  if err == nil && detachErr == nil {
+    stats.Detached++
+    stats.timeToDetach += detached.Sub(created)
+  }
+  discharged, err := time.Parse(TimeFormat, record.Transitions[StateDischarged])
+  if err == nil && detachErr == nil {
+    stats.discharged++
+    stats.timeToDischarge += discharged.Sub(detached)
+  }
+}
+
+// ==========================================================================================
+// finish - works out the percentages, average times and reputation of the stats once every
+// commitment has been counted. Agents are rated on their own obligation: the reputation is
+// the share of the commitments with an outcome that were discharged rather than violated,
+// or for creditors, who must detach them, detached rather than expired (unset until one of
+// them has been).
+// ==========================================================================================
+func (stats *Stats) finish(creditor bool) {
+  for state, count := range stats.Counts {
+    if stats.Total > 0 {
+      stats.Percentages[state] = 100 * float64(count) / float64(stats.Total)
+    }
+  }
+  if stats.Detached > 0 {
+    stats.AvgTimeToDetach = int64((stats.timeToDetach / time.Duration(stats.Detached)).Seconds())
+  }
+  if stats.discharged > 0 {
+    stats.AvgTimeToDischarge = int64((stats.timeToDischarge / time.Duration(stats.discharged)).Seconds())
+  }
+  kept, failed := stats.Counts[StateDischarged], stats.Counts[StateViolated]
+  if creditor {
+    kept, failed = stats.Detached, stats.Counts[StateExpired]
+  }
+  if kept + failed > 0 {
+    reputation := float64(kept) / float64(kept + failed)
+    stats.Reputation = &reputation
+  }
+}
+
+// ===============================================================================
+// richQuery - uses a query string to perform a query for commitments.
+//
+// Query string matching state database syntax is passed in and executed as is.
+// Supports ad hoc queries that can be defined at runtime by the client.
+// Only available on state databases that support rich query (e.g. CouchDB).
+// The first argument in the args list is the query string.
+// ===============================================================================
+func (t *SCC300NetworkChaincode) richQuery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+  // ==== Input sanitation ===== //
+  if len(args) < 1 {
+    return shim.Error("Incorrect number of arguments. Expecting 1")
+  }
+
+  // ==== Obtain query results ==== //
+  queryString := args[0]
+  queryResults, err := getQueryResultForQueryString(stub, queryString)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(queryResults)
+}
+
+// ===============================================================================
+// richQueryWithPagination - performs a rich query a page at a time, in key order
+// (see queryPage), so the query can't set its own sort, limit, skip or index.
+// args: [query, pageSize, bookmark ("" for the first page)]
+// ===============================================================================
+func (t *SCC300NetworkChaincode) richQueryWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+  if len(args) != 3 {
+    return shim.Error("Incorrect number of arguments. Expecting [<query>, <pageSize>, <bookmark>]")
+  }
+  pageSize, err := strconv.Atoi(args[1])
+  if err != nil || pageSize <= 0 {
+    return shim.Error("Page size must be a positive integer, got " + args[1])
+  }
+
+  request := map[string]interface{}{}
+  if err := json.Unmarshal([]byte(args[0]), &request); err != nil {
+    return shim.Error("Invalid query: " + err.Error())
+  }
+  selector, ok := request["selector"].(map[string]interface{})
+  if !ok {
+    return shim.Error("Invalid query: a selector is required")
+  }
+  for _, option := range []string{"sort", "limit", "skip", "use_index"} {
+    if _, ok := request[option]; ok {
+      return shim.Error("Invalid query: paginated queries are in key order, without " + option)
+    }
+  }
+
+  results, bookmark, err := queryPage(stub, pagedQuery{Selector: selector, Sort: []string{"_id"}}, pageSize, args[2], func(queryResponse *queryresult.KV) (interface{}, error) {
+    record := map[string]interface{}{}
+    if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+      return nil, err
+    }
+    return QueryResponse{Key: queryResponse.Key, Record: record}, nil
+  })
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  page := QueryPage{Records: []QueryResponse{}, FetchedRecordsCount: len(results), Bookmark: bookmark}
+  for _, result := range results {
+    page.Records = append(page.Records, result.(QueryResponse))
+  }
+
+  pageBytes, err := json.Marshal(page)
+  if err != nil {
+    return shim.Error(err.Error())
+  }
+  return shim.Success(pageBytes)
+}
+
+// Most queries made for a page of results, when most of those fetched are filtered out (see queryPage)
+const maxPageQueries = 5
+
+// A rich query that can be run a page at a time (see queryPage)
+type pagedQuery struct {
+  Selector  map[string]interface{}  // Selector - the CouchDB selector of the results
+  Sort      []string                // Sort - the fields results are sorted by, the last of which tells results apart and is paged on
+  Index     []string                // Index - the design document and name of the index sorted on, if any
+}
+
+// =========================================================================================
+// queryPage - obtains one page of the results of a rich query, returning the bookmark of the
+// next page ("" on the last page). Results are decoded in turn, and those decoded to nil don't
+// count toward the page. Fabric 1.1 peers can't paginate queries (GetQueryResultWithPagination
+// is 1.3+), so pages are ranges of the field paged on instead: the bookmark is the value of the
+// last result of the page (base64 URL encoded, as keys can hold null bytes), and each page
+// selects the results after it (after "" on the first page, so the field is always selected
+// on and its index can be used), limited to the page and one more to tell whether a next page
+// exists. Results decoded to nil leave the page short, and are made up for by querying again
+// from the last one, up to maxPageQueries queries in all: past that, the page is returned
+// short (even empty) with the bookmark of the last result looked at, so clients must follow
+// bookmarks until there are none. A page size of 0 obtains every result in one query.
+// =========================================================================================
+func queryPage(stub shim.ChaincodeStubInterface, query pagedQuery, pageSize int, bookmark string, decode func(*queryresult.KV) (interface{}, error)) ([]interface{}, string, error) {
+  pagedOn := query.Sort[len(query.Sort)-1]
+  after, err := base64.RawURLEncoding.DecodeString(bookmark)
+  if err != nil {
+    return nil, "", errors.New("Invalid bookmark " + bookmark)
+  }
+
+  results := []interface{}{}
+  last := string(after)
+  for queries := 1; ; queries++ {
+    // ==== Select the results after the last one, up to a page and one more ==== //
+    selector := map[string]interface{}{"$and": []interface{}{
+      query.Selector,
+      map[string]interface{}{pagedOn: map[string]interface{}{"$gt": last}},
+    }}
+    request := map[string]interface{}{"selector": selector, "sort": query.Sort}
+    if query.Index != nil {
+      request["use_index"] = query.Index
+    }
+    limit := 0
+    if pageSize > 0 {
+      limit = pageSize + 1
+      request["limit"] = limit
+    }
+    queryBytes, err := json.Marshal(request)
+    if err != nil {
+      return nil, "", err
+    }
+
+    // ==== Fill the page ==== //
+    resultsIterator, err := stub.GetQueryResult(string(queryBytes))
+    if err != nil {
+      return nil, "", err
+    }
+    fetched := 0
+    for resultsIterator.HasNext() {
+      queryResponse, err := resultsIterator.Next()
+      if err != nil {
+        resultsIterator.Close()
+        return nil, "", err
+      }
+      fetched++
+      result, err := decode(queryResponse)
+      if err != nil {
+        resultsIterator.Close()
+        return nil, "", err
+      }
+      if result != nil {
+        if pageSize > 0 && len(results) == pageSize {
+          resultsIterator.Close()
+          return results, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+        }
+        results = append(results, result)
+      }
+      if last, err = fieldValue(queryResponse, pagedOn); err != nil {
+        resultsIterator.Close()
+        return nil, "", err
+      }
+    }
+    resultsIterator.Close()
+    if limit == 0 || fetched < limit {
+      return results, "", nil
+    } else if queries == maxPageQueries {
+      return results, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+    }
+  }
+}
+
+// =========================================================================================
+// fieldValue - the value of a top-level string field of a query result (_id is its key).
+// =========================================================================================
+func fieldValue(queryResponse *queryresult.KV, field string) (string, error) {
+  if field == "_id" {
+    return queryResponse.Key, nil
+  }
+  doc := map[string]interface{}{}
+  if err := json.Unmarshal(queryResponse.Value, &doc); err != nil {
+    return "", err
+  }
+  value, ok := doc[field].(string)
+  if !ok {
+    return "", fmt.Errorf("Result %s has no %s to page on", queryResponse.Key, field)
+  }
+  return value, nil
+}
+
+// =================================================================================
+// getQueryResultForQueryString - executes the passed in query string.
+// Result set is built and returned as a byte array containing the JSON results.
+// =================================================================================
+func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string) ([]byte, error) {
+
+  // ==== Obtain query result ==== //
+  resultsIterator, err := stub.GetQueryResult(queryString)
+  if err != nil {
+    return nil, err
+  }
+  defer resultsIterator.Close()
+
+  // ==== Construct query response ==== //
+  buffer, err := constructQueryResponseFromIterator(resultsIterator)
+  if err != nil {
+    return nil, err
+  }
+
+  return buffer.Bytes(), nil
+}
+
+// ============================================================================================
+// constructQueryResponseFromIterator - constructs a JSON array containing query results from
+// a given result iterator.
+// ============================================================================================
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*bytes.Buffer, error) {
+
+  // ==== Buffer is a JSON array containing QueryResults ==== //
+  var buffer bytes.Buffer
+  buffer.WriteString("[")
+
+  bArrayMemberAlreadyWritten := false
+  for resultsIterator.HasNext() {
+    queryResponse, err := resultsIterator.Next()
+    if err != nil {
+      return nil, err
+    }
+    // ==== Add a comma before array members, suppress it for the first array member ==== //
+    if bArrayMemberAlreadyWritten == true {
+      buffer.WriteString(",")
+    }
+    buffer.WriteString("{\"Key\":")
+    buffer.WriteString("\"")
+    buffer.WriteString(queryResponse.Key)
+    buffer.WriteString("\"")
+
+    buffer.WriteString(", \"Record\":")
+    // ==== Record is a JSON object, so we write as-is ==== //
+    buffer.WriteString(string(queryResponse.Value))
+    buffer.WriteString("}")
+    bArrayMemberAlreadyWritten = true
+  }
+  buffer.WriteString("]")
+
+  return &buffer, nil
+}
+
+// ======================================================================
+// compileSpec - compiles a specification source code into a go struct.
+// ======================================================================
+func compileSpec(source string) (res *q.Spec, err error) {
+  spec, diags := q.Parse(source)
+  if len(diags) > 0 {
+    return nil, fmt.Errorf("Syntax Error:\n%s", diags.Format(source))
+  }
+  fmt.Printf("\n%s spec compiled successfully %s \n", spec.Constraint.Name, GreenTick)
+  return spec, nil
+}
+
+// ======================================================================================
+// addDeadline - perform exact Go time arithmetic to obtain the deadline date for an event.
+// (e.g. deadline=5 means payment must occur within 5 days of the offer being created,
+// deadline=48h within 48 hours and deadline=10bd within 10 business days)
+// ======================================================================================
+func addDeadline(date string, deadline q.Deadline) (string, error) {
+  parsedDate, err := time.Parse(TimeFormat, date)
+  if err != nil {
+    return "", fmt.Errorf("invalid date %q to count the deadline from, expecting the format %s", date, TimeFormat)
+  }
+  return deadline.After(parsedDate).Format(TimeFormat), nil
+}
+
+// ======================================================================
+// isBeforeDeadline - checks whether a date falls before a deadline date.
+// A date that can't be parsed is an error rather than the zero time,
+// which would make every commitment fail straight away.
+// ======================================================================
+func isBeforeDeadline(date string, deadline string) (bool, error) {
+  parsedDate, err := time.Parse(TimeFormat, date)
+  if err != nil {
+    return false, fmt.Errorf("invalid date %q, expecting the format %s", date, TimeFormat)
+  }
+  parsedDeadline, err := time.Parse(TimeFormat, deadline)
+  if err != nil {
+    return false, fmt.Errorf("invalid deadline %q, expecting the format %s", deadline, TimeFormat)
+  }
+  return parsedDate.Before(parsedDeadline), nil
+}
+
+// =====================================================================================
+// findSpec - finds the compiled spec an event belongs to. Events of existing commitments,
+// and events naming their spec, belong to that spec. Otherwise the spec is found by the
+// create event name, which must then be unique to one spec (so every spec is compiled).
+// =====================================================================================
+func findSpec(specs *specCache, specName string, eventName string) (*q.Spec, error) {
+  if specName != "" {
+    return specs.latest(specName)
+  }
+  names, err := specs.names()
+  if err != nil {
+    return nil, err
+  }
+  var found *q.Spec
+  for _, name := range names {
+    spec, err := specs.latest(name)
+    if err != nil {
+      return nil, err
+    } else if spec == nil || spec.CreateEvent.Name != eventName {
+      continue
+    }
+    if found != nil {
+      return nil, fmt.Errorf("%s is an event of several specs (%s, %s), the spec must be given", eventName, found.Constraint.Name, spec.Constraint.Name)
+    }
+    found = spec
+  }
+  return found, nil
+}
+
+// ======================================================================
+// newCommitment - obtains the commitment returned to clients from its
+// record, evaluated as of a date.
+// ======================================================================
+func newCommitment(record *CommitmentRecord, asOf string) Commitment {
+  commitment := Commitment{
+    ComID: record.ComID,
+    Spec: record.Spec,
+    SpecVersion: specVersion(record.SpecVersion),
+    State: record.State,
+    Transitions: record.Transitions,
+    Debtor: record.Debtor,
+    Creditor: record.Creditor,
+    DebtorID: record.DebtorID,
+    CreditorID: record.CreditorID,
+    States: record.States,
+    Events: record.Events,
+  }
+
+  // ==== Only commitments in progress have a deadline to meet ==== //
+  if record.State == StateCreated {
+    commitment.Deadline = record.DetachDeadline
+  } else if record.State == StateDetached {
+    commitment.Deadline = record.DischargeDeadline
+  }
+  if commitment.Deadline != "" {
+    now, _ := time.Parse(TimeFormat, asOf)
+    deadline, _ := time.Parse(TimeFormat, commitment.Deadline)
+    commitment.TimeRemaining = int64(deadline.Sub(now).Seconds())
+  }
+  return commitment
+}
+
+// ======================================================================
+// putSpec - saves a version of a spec under its version key, and under
+// its name as the latest version, which new commitments are created
+// under. Only the latest version has the "spec" docType, so queries for
+// registered specs find each spec once.
+// ======================================================================
+func putSpec(stub shim.ChaincodeStubInterface, specName string, source string, version int) error {
+  specRes := &Spec{ObjectType: "specVersion", Name: specName, Source: source, Version: version}
+  versionAsBytes, err := json.Marshal(specRes)
+  if err != nil {
+    return err
+  }
+  if err := stub.PutState(specVersionKey(specName, version), versionAsBytes); err != nil {
+    return err
+  }
+  specRes.ObjectType = "spec"
+  latestAsBytes, err := json.Marshal(specRes)
+  if err != nil {
+    return err
+  }
+  return stub.PutState(specName, latestAsBytes)
+}
+
+// ======================================================================
+// getSpecVersion - obtains a version of a spec. Specs stored before
+// versioning only have their name key, which holds version 1.
+// ======================================================================
+func getSpecVersion(stub shim.ChaincodeStubInterface, specName string, version int) (*Spec, error) {
+  version = specVersion(version)
+  specAsBytes, err := stub.GetState(specVersionKey(specName, version))
+  if err != nil {
+    return nil, err
+  }
+  if specAsBytes == nil && version == 1 {
+    if specAsBytes, err = stub.GetState(specName); err != nil {
+      return nil, err
+    }
+  }
+  if specAsBytes == nil {
+    return nil, fmt.Errorf("spec %s has no version %d", specName, version)
+  }
+  com := &Spec{}
+  if err := json.Unmarshal(specAsBytes, com); err != nil {
+    return nil, err
+  }
+  com.Version = version
+  return com, nil
+}
+
+// ======================================================================
+// getCompiledSpec - obtains and compiles the latest version of a spec.
+// ======================================================================
+func getCompiledSpec(stub shim.ChaincodeStubInterface, specName string) (*q.Spec, error) {
+  specAsBytes, err := stub.GetState(specName)
+  if err != nil {
+    return nil, err
+  } else if specAsBytes == nil {
+    return nil, errors.New("Spec does not exist: " + specName)
+  }
+  com := Spec{}
+  if err := json.Unmarshal(specAsBytes, &com); err != nil {
+    return nil, err
+  }
+  return compileSpec(com.Source)
+}
+
+// ======================================================================
+// getCompiledSpecVersion - obtains and compiles a version of a spec,
+// e.g. the version a commitment was created under.
+// ======================================================================
+func getCompiledSpecVersion(stub shim.ChaincodeStubInterface, specName string, version int) (*q.Spec, error) {
+  com, err := getSpecVersion(stub, specName, version)
+  if err != nil {
+    return nil, err
+  }
+  return compileSpec(com.Source)
+}
+
+// ======================================================================
+// specCache - the specs used by a transaction, each obtained and compiled
+// the first time it's needed rather than every registered spec up front.
+// ======================================================================
+type specCache struct {
+  stub      shim.ChaincodeStubInterface
+  specs     map[string]*q.Spec  // specs - by name for the latest version, by version key otherwise (nil if it doesn't compile)
+  specNames []string            // specNames - every registered spec, once listed
+}
+
+func newSpecCache(stub shim.ChaincodeStubInterface) *specCache {
+  return &specCache{stub: stub, specs: make(map[string]*q.Spec)}
+}
+
+// The names of every registered spec
+func (cache *specCache) names() ([]string, error) {
+  if cache.specNames == nil {
+    names, err := getSpecNames(cache.stub)
+    if err != nil {
+      return nil, err
+    }
+    cache.specNames = names
+  }
+  return cache.specNames, nil
+}
+
+// The latest version of a spec, nil if it isn't registered or doesn't compile
+func (cache *specCache) latest(specName string) (*q.Spec, error) {
+  if spec, ok := cache.specs[specName]; ok {
+    return spec, nil
+  }
+  specAsBytes, err := cache.stub.GetState(specName)
+  if err != nil {
+    return nil, err
+  }
+  var spec *q.Spec
+  if specAsBytes != nil {
+    com := Spec{}
+    json.Unmarshal(specAsBytes, &com)
+    if spec, err = compileSpec(com.Source); err != nil {
+      fmt.Println("Skipping spec " + specName + ": " + err.Error())
+      spec = nil
+    }
+  }
+  cache.specs[specName] = spec
+  return spec, nil
+}
+
+// A version of a spec (see getCompiledSpecVersion)
+func (cache *specCache) version(specName string, version int) (*q.Spec, error) {
+  key := specVersionKey(specName, specVersion(version))
+  if spec, ok := cache.specs[key]; ok {
+    return spec, nil
+  }
+  spec, err := getCompiledSpecVersion(cache.stub, specName, version)
+  if err != nil {
+    return nil, err
+  }
+  cache.specs[key] = spec
+  return spec, nil
+}
+
+// ======================================================================
+// specVersionKey - obtains the state key of a version of a spec
+// (e.g. SellItem@v2). Spec names are identifiers, so can't contain '@'.
+// ======================================================================
+func specVersionKey(specName string, version int) string {
+  return fmt.Sprintf("%s@v%d", specName, version)
+}
+
+// ======================================================================
+// specVersion - the version of a spec or commitment. Those stored
+// before specs were versioned have no version, i.e. version 1.
+// ======================================================================
+func specVersion(version int) int {
+  if version < 1 {
+    return 1
+  }
+  return version
+}
+
+// ======================================================================
+// eventKey - obtains the state key of an occurrence of an event of a
+// commitment, scoped to its spec so specs can share event names. Each
+// occurrence has its own key (the transaction ID and its position in
+// the transaction), so events are only ever appended.
+// ======================================================================
+func eventKey(stub shim.ChaincodeStubInterface, specName string, eventName string, comID string, txID string, position int) (string, error) {
+  return stub.CreateCompositeKey("event", []string{specName, eventName, comID, txID, strconv.Itoa(position)})
+}
+
+// ======================================================================
+// eventKeys - obtains the state keys of every occurrence of an event
+// of a commitment.
+// ======================================================================
+func eventKeys(stub shim.ChaincodeStubInterface, specName string, eventName string, comID string) ([]string, error) {
+  resultsIterator, err := stub.GetStateByPartialCompositeKey("event", []string{specName, eventName, comID})
+  if err != nil {
+    return nil, err
+  }
+  defer resultsIterator.Close()
+
+  keys := []string{}
+  for resultsIterator.HasNext() {
+    queryResponse, err := resultsIterator.Next()
+    if err != nil {
+      return nil, err
+    }
+    keys = append(keys, queryResponse.Key)
+  }
+  return keys, nil
+}
+
+// ======================================================================
+// commitmentKey - obtains the state key of a commitment record. IDs are
+// only unique within a spec, like the keys of its events (see eventKey).
+// ======================================================================
+func commitmentKey(stub shim.ChaincodeStubInterface, specName string, comID string) (string, error) {
+  return stub.CreateCompositeKey("commitment", []string{specName, comID})
+}
+
+// ==================================================================================
+// getCommitmentRecord - reads the record of a commitment of a spec, preferring records
+// updated in this transaction (keyed by commitmentKey). Returns nil if the commitment
+// doesn't exist.
+// ==================================================================================
+func getCommitmentRecord(stub shim.ChaincodeStubInterface, records map[string]*CommitmentRecord, specName string, comID string) (*CommitmentRecord, error) {
+  key, err := commitmentKey(stub, specName, comID)
+  if err != nil {
+    return nil, err
+  }
+  if record, ok := records[key]; ok {
+    return record, nil
+  }
+  recordAsBytes, err := stub.GetState(key)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to get commitment %s: %s", comID, err.Error())
+  } else if recordAsBytes == nil {
+    return nil, nil
+  }
+  record := &CommitmentRecord{}
+  if err := json.Unmarshal(recordAsBytes, record); err != nil {
+    return nil, err
+  }
+  return record, nil
+}
+
+// ==================================================================================
+// findCommitmentRecord - reads the record of a commitment of a spec, or of any spec
+// if specName is "" (the ID must then only be used by one spec). Returns nil if the
+// commitment doesn't exist.
+// ==================================================================================
+func findCommitmentRecord(stub shim.ChaincodeStubInterface, specName string, comID string) (*CommitmentRecord, error) {
+  if specName != "" {
+    return getCommitmentRecord(stub, nil, specName, comID)
+  }
+  specNames, err := getSpecNames(stub)
+  if err != nil {
+    return nil, err
+  }
+  return findRecordInSpecs(stub, nil, specNames, comID)
+}
+
+// Finds the record of a commitment among the given specs, nil if none of them has it
+func findRecordInSpecs(stub shim.ChaincodeStubInterface, records map[string]*CommitmentRecord, specNames []string, comID string) (*CommitmentRecord, error) {
+  var found *CommitmentRecord
+  for _, specName := range specNames {
+    record, err := getCommitmentRecord(stub, records, specName, comID)
+    if err != nil {
+      return nil, err
+    } else if record == nil || record.State == "" {
+      continue
+    }
+    if found != nil {
+      return nil, fmt.Errorf("Commitment %s exists in several specs (%s, %s), the spec must be given", comID, found.Spec, record.Spec)
+    }
+    found = record
+  }
+  return found, nil
+}
+
+// ======================================================================
+// getSpecNames - obtains the names of the registered specs.
+// ======================================================================
+func getSpecNames(stub shim.ChaincodeStubInterface) ([]string, error) {
+  resultsIterator, err := stub.GetQueryResult(GetSpecsQuery)
+  if err != nil {
+    return nil, err
+  }
+  defer resultsIterator.Close()
+
+  names := []string{}
+  for resultsIterator.HasNext() {
+    queryResponse, err := resultsIterator.Next()
+    if err != nil {
+      return nil, err
+    }
+    com := Spec{}
+    json.Unmarshal(queryResponse.Value, &com)
+    names = append(names, com.Name)
+  }
+  return names, nil
+}
+
+// The argument at index i, "" if it wasn't given
+func optionalArg(args []string, i int) string {
+  if i < len(args) {
+    return args[i]
+  }
+  return ""
+}
+
+// ======================================================================
+// emitTransitions - sets the "eventInvoke" event of this transaction,
+// carrying the commitment transitions it made as a JSON array.
+// ======================================================================
+func emitTransitions(stub shim.ChaincodeStubInterface, transitions []Transition) error {
+  payload, err := json.Marshal(transitions)
+  if err != nil {
+    return err
+  }
+  return stub.SetEvent("eventInvoke", payload)
+}
+
+// ======================================================================
+// putSubmitter - saves the identity of the client submitting this
+// transaction, keyed by the transaction ID.
+// ======================================================================
+func putSubmitter(stub shim.ChaincodeStubInterface) error {
+  client, err := getClient(stub)
+  if err != nil {
+    return err
+  }
+  submitter := Submitter{ObjectType: "tx", MSPID: client.MSPID, Name: client.Name}
+  key, err := stub.CreateCompositeKey("tx", []string{stub.GetTxID()})
+  if err != nil {
+    return err
+  }
+  submitterJSON, err := json.Marshal(submitter)
+  if err != nil {
+    return err
+  }
+  return stub.PutState(key, submitterJSON)
+}
+
+// ======================================================================
+// txDate - obtains the timestamp of this transaction, set by the client
+// when it creates the proposal and identical on every endorsing peer.
+// ======================================================================
+func txDate(stub shim.ChaincodeStubInterface) (string, error) {
+  ts, err := stub.GetTxTimestamp()
+  if err != nil {
+    return "", fmt.Errorf("Failed to get the transaction timestamp: %s", err.Error())
+  }
+  return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(TimeFormat), nil
+}
+
+// ======================================================================
+// evaluationDate - obtains the "as of" date of a query from its
+// arguments, defaulting to the transaction timestamp.
+// ======================================================================
+func evaluationDate(stub shim.ChaincodeStubInterface, args []string, i int) (string, error) {
+  if len(args) <= i || args[i] == "" {
+    return txDate(stub)
+  }
+  if _, err := time.Parse(TimeFormat, args[i]); err != nil {
+    return "", fmt.Errorf("Invalid as of date %s, expecting the format %s", args[i], TimeFormat)
+  }
+  return args[i], nil
+}
+
+// ======================================================================
+// getClient - obtains the identity of the client submitting this
+// transaction from its creator certificate.
+// ======================================================================
+func getClient(stub shim.ChaincodeStubInterface) (Party, error) {
+  mspID, err := cid.GetMSPID(stub)
+  if err != nil {
+    return Party{}, fmt.Errorf("Failed to get the client identity: %s", err.Error())
+  }
+  client := Party{MSPID: mspID}
+  if cert, err := cid.GetX509Certificate(stub); err == nil && cert != nil {
+    client.Name = cert.Subject.CommonName
+  }
+  return client, nil
+}
+
+// ======================================================================================
+// parseParty - reads a client identity given as [<mspID>:]<commonName>, e.g.
+// User2@org1.example.com or Org1MSP:User2@org1.example.com. The MSP defaults to mspID.
+// ======================================================================================
+func parseParty(identity string, mspID string) (*Party, error) {
+  name := identity
+  if i := strings.Index(identity, ":"); i >= 0 {
+    mspID, name = identity[:i], identity[i+1:]
+  }
+  if mspID == "" || name == "" {
+    return nil, fmt.Errorf("Invalid client identity %q, expecting [<mspID>:]<commonName>", identity)
+  }
+  return &Party{MSPID: mspID, Name: name}, nil
+}
+
+// ==========================================================================================
+// checkEvent - validates an event before it is written. The docType must be an event of a
+// registered spec (for detach and discharge events, the version of the spec the commitment
+// was created under, see findSpec), with every argument the spec declares and no others;
+// the fields the chaincode sets (date, and the parties outside create events) are rejected.
+// The event is tagged with its spec. Detach and discharge events need an existing commitment and
+// can occur any number of times; a commitment is only created once, under the latest version
+// of its spec. Returns the record of the commitment (a new one for create events) and its spec.
+// ==========================================================================================
+func checkEvent(stub shim.ChaincodeStubInterface, specs *specCache, records map[string]*CommitmentRecord, event map[string]string) (*CommitmentRecord, *q.Spec, error) {
+  eventName, comID := event["docType"], event["comID"]
+  if eventName == "" || comID == "" {
+    return nil, nil, errors.New("docType and comID are required")
+  }
+
+  // ==== Find the commitment record and spec this event belongs to (the commitment's spec if it isn't named) ==== //
+  specName := event["spec"]
+  var record *CommitmentRecord
+  var err error
+  if specName != "" {
+    record, err = getCommitmentRecord(stub, records, specName, comID)
+  } else {
+    var specNames []string
+    if specNames, err = specs.names(); err == nil {
+      record, err = findRecordInSpecs(stub, records, specNames, comID)
+    }
+  }
+  if err != nil {
+    return nil, nil, err
+  }
+  if record == nil || record.State == "" {
+    record = &CommitmentRecord{ObjectType: "commitment", ComID: comID}
+  }
+  var spec *q.Spec
+  if record.State != "" {
+    // ==== Events of existing commitments follow the spec version they were created under ==== //
+    spec, err = specs.version(record.Spec, record.SpecVersion)
+  } else {
+    spec, err = findSpec(specs, specName, eventName)
+  }
+  if err != nil {
+    return nil, nil, err
+  }
+  if spec == nil {
+    if specName != "" {
+      return nil, nil, &statusError{StatusNotFound, fmt.Sprintf("spec %s is not registered", specName)}
+    }
+    names, err := specs.names()
+    if err != nil {
+      return nil, nil, err
+    }
+    for _, name := range names {
+      if other, err := specs.latest(name); err != nil {
+        return nil, nil, err
+      } else if other != nil && other.FindEvent(eventName) != nil {
+        return nil, nil, &statusError{StatusNotFound, fmt.Sprintf("commitment %s does not exist", comID)}
+      }
+    }
+    return nil, nil, fmt.Errorf("%s is not an event of any registered spec", eventName)
+  }
+  specEvent := spec.FindEvent(eventName)
+  if specEvent == nil {
+    return nil, nil, fmt.Errorf("%s is not an event of spec %s", eventName, spec.Constraint.Name)
+  }
+  if record.State == "" && eventName != spec.CreateEvent.Name {
+    return nil, nil, &statusError{StatusNotFound, fmt.Sprintf("commitment %s does not exist", comID)}
+  }
+  event["spec"] = spec.Constraint.Name
+
+  // ==== A commitment is created once, under the latest version of its spec ==== //
+  if eventName == spec.CreateEvent.Name && record.State != "" {
+    return nil, nil, fmt.Errorf("commitment %s already exists", comID)
+  } else if eventName == spec.CreateEvent.Name {
+    latest, err := stub.GetState(spec.Constraint.Name)
+    if err != nil {
+      return nil, nil, err
+    }
+    com := Spec{}
+    json.Unmarshal(latest, &com)
+    record.SpecVersion = specVersion(com.Version)
+  }
+
+  // ==== Reject payloads that don't match the arguments declared in the spec ==== //
+  var extra []string
+  if eventName == spec.CreateEvent.Name {
+    extra = []string{"debtor", "creditor", "debtorID", "creditorID"}
+  }
+  if err := specEvent.Validate(event, extra...); err != nil {
+    return nil, nil, err
+  }
+  if declared, ok := event["businessDate"]; ok {
+    if _, err := time.Parse(TimeFormat, declared); err != nil {
+      return nil, nil, fmt.Errorf("invalid business date %s, expecting the format %s", declared, TimeFormat)
+    }
+  }
+  return record, spec, nil
+}
+
+// ==========================================================================================
+// authorizeEvent - checks that the client may submit an event of a commitment. A create
+// event binds the debtor role to the client, who makes the commitment (a debtorID field
+// must name the client), and the creditor role to the creditorID field.
+// Detach events are only accepted from the creditor and discharge events from the debtor.
+// Commitments created before roles were bound accept no more events (see authorizeRole).
+// ==========================================================================================
+func authorizeEvent(record *CommitmentRecord, spec *q.Spec, eventName string, event map[string]string, client Party) error {
+  switch eventName {
+    case spec.CreateEvent.Name:
+      debtor := &Party{MSPID: client.MSPID, Name: client.Name}
+      if event["debtorID"] != "" {
+        party, err := parseParty(event["debtorID"], client.MSPID)
+        if err != nil {
+          return err
+        }
+        if !client.is(party) {
+          return &statusError{StatusForbidden, fmt.Sprintf("Not authorized: only the debtor of commitment %s (%s) can submit %s, not %s", record.ComID, party, eventName, client)}
+        }
+      }
+      if event["creditorID"] == "" {
+        return fmt.Errorf("%s event of commitment %s must bind the creditor to a client identity (creditorID)", eventName, record.ComID)
+      }
+      creditor, err := parseParty(event["creditorID"], client.MSPID)
+      if err != nil {
+        return err
+      }
+      record.DebtorID = debtor
+      record.CreditorID = creditor
+    case spec.DetachEvent.Name:
+      return authorizeRole(record, RoleCreditor, eventName, client)
+    case spec.DischargeEvent.Name:
+      return authorizeRole(record, RoleDebtor, eventName, client)
+  }
+  return nil
+}
+
+// ======================================================================================
+// authorizeRead - checks that the client submitting a query may read a commitment: only
+// its debtor and creditor can, or anyone for commitments created before roles were bound.
+// ======================================================================================
+func authorizeRead(stub shim.ChaincodeStubInterface, record *CommitmentRecord) error {
+  client, err := getClient(stub)
+  if err != nil {
+    return err
+  }
+  if (record.DebtorID == nil && record.CreditorID == nil) || client.is(record.DebtorID) || client.is(record.CreditorID) {
+    return nil
+  }
+  return &statusError{StatusForbidden, fmt.Sprintf("Not authorized: only the debtor and creditor of commitment %s can read it, not %s", record.ComID, client)}
+}
+
+// ======================================================================================
+// partySelector - the selector condition on the commitment records a client may read
+// (see authorizeRead), so lists of commitments only hold those.
+// ======================================================================================
+func partySelector(client Party) map[string]interface{} {
+  return map[string]interface{}{"$or": []interface{}{
+    map[string]interface{}{"debtorID.mspID": client.MSPID, "debtorID.name": client.Name},
+    map[string]interface{}{"creditorID.mspID": client.MSPID, "creditorID.name": client.Name},
+    map[string]interface{}{"debtorID": map[string]interface{}{"$exists": false}, "creditorID": map[string]interface{}{"$exists": false}},
+  }}
+}
+
+// ======================================================================
+// authorizeRole - checks that the client holds a role of a commitment.
+// No client holds the roles of commitments created before they were
+// bound to client identities.
+// ======================================================================
+func authorizeRole(record *CommitmentRecord, role string, action string, client Party) error {
+  party := record.DebtorID
+  if role == RoleCreditor {
+    party = record.CreditorID
+  }
+  if party == nil {
+    return &statusError{StatusForbidden, fmt.Sprintf("Not authorized: commitment %s was created before roles were bound to client identities, no client can submit %s", record.ComID, action)}
+  } else if client.is(party) {
+    return nil
+  }
+  return &statusError{StatusForbidden, fmt.Sprintf("Not authorized: only the %s of commitment %s (%s) can submit %s, not %s", role, record.ComID, party, action, client)}
+}
+
+// Whether this client is the given party
+func (client Party) is(party *Party) bool {
+  return party != nil && client.MSPID == party.MSPID && client.Name == party.Name
+}
+
+func (party Party) String() string {
+  return party.MSPID + ":" + party.Name
+}
+
+// A request refused with a status other than shim.ERROR (StatusForbidden or StatusNotFound)
+type statusError struct {
+  status  int32
+  msg     string
+}
+
+func (err *statusError) Error() string {
+  return err.msg
+}
+
+// =======================================================================
+// refuse - responds to a request refused because of what was asked for.
+// =======================================================================
+func refuse(status int32, msg string) pb.Response {
+  return pb.Response{Status: status, Message: msg}
+}
+
+// =======================================================================
+// errorResponse - responds with an error, with its status if it has one.
+// =======================================================================
+func errorResponse(err error) pb.Response {
+  if refused, ok := err.(*statusError); ok {
+    return refuse(refused.status, refused.msg)
+  }
+  return shim.Error(err.Error())
+}
+
+// Status of a batch of rejections after rejecting one more for err: the status of
+// every rejection if they share one, otherwise shim.ERROR
+func rejectionStatus(status int32, err error) int32 {
+  refused, ok := err.(*statusError)
+  if !ok || (status != 0 && status != refused.status) {
+    return shim.ERROR
+  }
+  return refused.status
+}
+
+// ======================================================================
+// getSubmitter - reads the identity of the client that submitted a
+// transaction. Returns nil for transactions made before it was recorded.
+// ======================================================================
+func getSubmitter(stub shim.ChaincodeStubInterface, txID string) (*Submitter, error) {
+  key, err := stub.CreateCompositeKey("tx", []string{txID})
+  if err != nil {
+    return nil, err
+  }
+  submitterAsBytes, err := stub.GetState(key)
+  if err != nil || submitterAsBytes == nil {
+    return nil, err
+  }
+  submitter := &Submitter{}
+  if err := json.Unmarshal(submitterAsBytes, submitter); err != nil {
+    return nil, err
+  }
+  return submitter, nil
+}
+
+// ======================================================================
+// putCommitmentRecord - saves a commitment record to state, along with
+// the deadline in force for selectors (see stateSelector).
+// ======================================================================
+func putCommitmentRecord(stub shim.ChaincodeStubInterface, record *CommitmentRecord) error {
+  key, err := commitmentKey(stub, record.Spec, record.ComID)
+  if err != nil {
+    return err
+  }
+  record.DeadlineAt = ""
+  deadline := record.DetachDeadline
+  if record.State == StateDetached {
+    deadline = record.DischargeDeadline
+  }
+  if date, err := time.Parse(TimeFormat, deadline); err == nil && (record.State == StateCreated || record.State == StateDetached) {
+    record.DeadlineAt = date.Format(time.RFC3339)
+  }
+  recordJSONasBytes, err := json.Marshal(record)
+  if err != nil {
+    return err
+  }
+  return stub.PutState(key, recordJSONasBytes)
+}
+
+// =============================================================================
+// commitmentsToBytes - converts a slice of commitment structs to a byte array.
+// =============================================================================
+func commitmentsToBytes(commitments []Commitment) (res []byte, err error) {
+  buf := new(bytes.Buffer)
+  b, err := json.Marshal(commitments)
+  if err != nil {
+    return nil, err
+  }
+  err = binary.Write(buf, binary.BigEndian, &b)
+  if err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
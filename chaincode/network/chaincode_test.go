@@ -0,0 +1,184 @@
+package network
+
+import (
+  "testing"
+
+  "github.com/hyperledger/fabric/core/chaincode/shim"
+  q "github.com/scc300/scc300-network/chaincode/quark"
+)
+
+func TestAddDeadline(t *testing.T) {
+  tests := []struct {
+    date      string
+    deadline  q.Deadline
+    due       string  // "" if the date is invalid
+  }{
+    {"Fri Jan  5 17:30:00 2018", q.Deadline{Amount: 5, Unit: q.Days}, "Wed Jan 10 17:30:00 2018"},
+    {"Fri Jan  5 17:30:00 2018", q.Deadline{Amount: 45, Unit: q.Minutes}, "Fri Jan  5 18:15:00 2018"},
+    {"Fri Jan  5 17:30:00 2018", q.Deadline{Amount: 1, Unit: q.BusinessDays}, "Mon Jan  8 17:30:00 2018"},
+    {"Sun Dec 31 23:00:00 2017", q.Deadline{Amount: 2, Unit: q.Hours}, "Mon Jan  1 01:00:00 2018"},
+    {"Mon Feb 26 12:00:00 2018", q.Deadline{Amount: 1, Unit: q.Weeks}, "Mon Mar  5 12:00:00 2018"},
+    {"2018-01-05T17:30:00Z", q.Deadline{Amount: 5, Unit: q.Days}, ""},
+    {"", q.Deadline{Amount: 5, Unit: q.Days}, ""},
+  }
+
+  for _, test := range tests {
+    due, err := addDeadline(test.date, test.deadline)
+    if test.due == "" && err == nil {
+      t.Errorf("%v after %q = %q, expected an error", test.deadline, test.date, due)
+    } else if test.due != "" && (err != nil || due != test.due) {
+      t.Errorf("%v after %q = %q, %v, expected %q", test.deadline, test.date, due, err, test.due)
+    }
+  }
+}
+
+func TestIsBeforeDeadline(t *testing.T) {
+  deadline := "Wed Jan 10 17:30:00 2018"
+  tests := []struct {
+    date    string
+    before  bool
+    valid   bool
+  }{
+    {"Wed Jan 10 17:29:59 2018", true, true},
+    {"Wed Jan 10 17:30:00 2018", false, true},  // the deadline itself is too late
+    {"Thu Jan 11 09:00:00 2018", false, true},
+    {"Fri Jan  5 17:30:00 2018", true, true},
+    {"10/01/2018", false, false},
+  }
+
+  for _, test := range tests {
+    before, err := isBeforeDeadline(test.date, deadline)
+    if test.valid != (err == nil) || before != test.before {
+      t.Errorf("isBeforeDeadline(%q, %q) = %v, %v", test.date, deadline, before, err)
+    }
+  }
+  if _, err := isBeforeDeadline("Fri Jan  5 17:30:00 2018", "soon"); err == nil {
+    t.Error("compared a date with an invalid deadline")
+  }
+}
+
+func TestCheckDeadlines(t *testing.T) {
+  tests := []struct {
+    state     string
+    now       string
+    expected  string
+  }{
+    {StateCreated, "Fri Jan  5 12:00:00 2018", StateCreated},
+    {StateCreated, "Wed Jan 10 17:30:00 2018", StateExpired},
+    {StateDetached, "Wed Jan 10 17:30:00 2018", StateDetached},
+    {StateDetached, "Mon Jan 15 17:30:00 2018", StateViolated},
+    {StateDischarged, "Mon Jan 15 17:30:00 2018", StateDischarged},
+    {StateCancelled, "Mon Jan 15 17:30:00 2018", StateCancelled},
+  }
+
+  for _, test := range tests {
+    record := &CommitmentRecord{
+      ComID: "c1",
+      State: test.state,
+      Transitions: map[string]string{StateCreated: "Fri Jan  5 10:00:00 2018"},
+      DetachDeadline: "Wed Jan 10 17:30:00 2018",
+      DischargeDeadline: "Mon Jan 15 17:30:00 2018",
+    }
+    if err := checkDeadlines(record, test.now); err != nil {
+      t.Fatal(err)
+    }
+    if record.State != test.expected {
+      t.Errorf("%s commitment as of %s is %s, expected %s", test.state, test.now, record.State, test.expected)
+    }
+    if record.State != test.state && record.Transitions[record.State] == "" {
+      t.Errorf("no %s transition in %v", record.State, record.Transitions)
+    }
+  }
+  record := &CommitmentRecord{ComID: "c1", State: StateCreated, Transitions: map[string]string{}, DetachDeadline: "soon"}
+  if err := checkDeadlines(record, "Fri Jan  5 12:00:00 2018"); err == nil || record.State != StateCreated {
+    t.Errorf("checked an invalid deadline: %v, the commitment is %s", err, record.State)
+  }
+}
+
+func TestAuthorizeRole(t *testing.T) {
+  debtor := &Party{MSPID: "Org1MSP", Name: "User1@org1.hf.scc300.io"}
+  creditor := &Party{MSPID: "Org1MSP", Name: "User2@org1.hf.scc300.io"}
+  tests := []struct {
+    name        string
+    debtorID    *Party
+    role        string
+    client      Party
+    authorized  bool
+  }{
+    {"the debtor", debtor, RoleDebtor, *debtor, true},
+    {"the creditor", debtor, RoleCreditor, *creditor, true},
+    {"the creditor in the debtor role", debtor, RoleDebtor, *creditor, false},
+    {"the same name in another MSP", debtor, RoleDebtor, Party{MSPID: "Org2MSP", Name: debtor.Name}, false},
+    {"a commitment created before roles were bound", nil, RoleDebtor, *debtor, false},
+  }
+
+  for _, test := range tests {
+    record := &CommitmentRecord{ComID: "c1", DebtorID: test.debtorID, CreditorID: creditor}
+    if test.debtorID == nil {
+      record.CreditorID = nil
+    }
+    err := authorizeRole(record, test.role, "cancel", test.client)
+    if test.authorized != (err == nil) {
+      t.Errorf("%s: %v", test.name, err)
+    } else if err != nil && errorResponse(err).Status != StatusForbidden {
+      t.Errorf("%s: refused with status %d, expected %d", test.name, errorResponse(err).Status, StatusForbidden)
+    }
+  }
+}
+
+func TestApplyEventAfterDeadline(t *testing.T) {
+  spec, err := compileSpec("spec SellItem dID to cID\n  create Offer [item]\n  detach Pay [amount] deadline=1w\n  discharge Delivery [courier] deadline=1w")
+  if err != nil {
+    t.Fatal(err)
+  }
+  tests := []struct {
+    events    [][]string  // name and date of each event
+    state     string
+    failedAt  string      // the transition date expected, the missed deadline rather than the late event's
+  }{
+    {[][]string{{"Offer", "Fri Jan  5 10:00:00 2018"}, {"Pay", "Mon Jan 15 10:00:00 2018"}}, StateExpired, "Fri Jan 12 10:00:00 2018"},
+    {[][]string{{"Offer", "Fri Jan  5 10:00:00 2018"}, {"Pay", "Sat Jan  6 10:00:00 2018"}, {"Delivery", "Mon Jan 15 10:00:00 2018"}}, StateViolated, "Sat Jan 13 10:00:00 2018"},
+  }
+
+  for _, test := range tests {
+    record := &CommitmentRecord{ComID: "c1"}
+    for _, event := range test.events {
+      if err := applyEvent(record, spec, event[0], map[string]string{"docType": event[0], "comID": "c1", "date": event[1]}); err != nil {
+        t.Fatalf("%s: %v", event[0], err)
+      }
+    }
+    if record.State != test.state || record.Transitions[test.state] != test.failedAt {
+      t.Errorf("commitment is %s with transitions %v, expected %s at %s", record.State, record.Transitions, test.state, test.failedAt)
+    }
+  }
+}
+
+func TestSpecCache(t *testing.T) {
+  stub := shim.NewMockStub("scc300-network", new(SCC300NetworkChaincode))
+  stub.MockTransactionStart("tx1")
+  for _, name := range []string{"SellItem", "SellBook"} {
+    source := "spec " + name + " dID to cID\n  create Offer [item]\n  detach Pay [amount] deadline=1w\n  discharge Delivery [courier] deadline=1w"
+    if err := putSpec(stub, name, source, 1); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  // ==== Only the specs asked for are compiled, once each ==== //
+  cache := newSpecCache(stub)
+  first, err := cache.latest("SellItem")
+  if err != nil || first == nil || first.Constraint.Name != "SellItem" {
+    t.Fatalf("latest SellItem = %v, %v", first, err)
+  }
+  if again, _ := cache.latest("SellItem"); again != first {
+    t.Error("compiled SellItem again")
+  }
+  if len(cache.specs) != 1 {
+    t.Errorf("compiled %d specs, expected only SellItem", len(cache.specs))
+  }
+  if version, err := cache.version("SellItem", 1); err != nil || version == nil || version == first {
+    t.Errorf("SellItem version 1 = %v, %v, expected it compiled on its own", version, err)
+  }
+  if missing, err := cache.latest("SellDesk"); missing != nil || err != nil {
+    t.Errorf("latest of a spec that isn't registered = %v, %v", missing, err)
+  }
+}
@@ -0,0 +1,84 @@
+package quark
+
+import (
+  "fmt"
+  "regexp"
+  "strconv"
+  "time"
+)
+
+// Deadline units
+const (
+  Minutes      = "m"
+  Hours        = "h"
+  Days         = "d"
+  BusinessDays = "bd"
+  Weeks        = "w"
+)
+
+// Matches deadline values such as 5, 30m, 48h, 10bd or 2w (a number without a unit is in days)
+var deadlineRegexp = regexp.MustCompile(`^([0-9]+)(m|h|d|bd|w)?$`)
+
+// Deadline is the time allowed for a detach or discharge event (e.g. deadline=48h)
+type Deadline struct {
+  Amount  int
+  Unit    string
+}
+
+// Parses a deadline value, defaulting to days when no unit is given
+func ParseDeadline(value string) (Deadline, error) {
+  match := deadlineRegexp.FindStringSubmatch(value)
+  if match == nil {
+    return Deadline{}, fmt.Errorf("invalid deadline %q, expected a number of m, h, d, bd or w (e.g. 48h, 10bd)", value)
+  }
+  amount, err := strconv.Atoi(match[1])
+  if err != nil {
+    return Deadline{}, fmt.Errorf("invalid deadline %q: %v", value, err)
+  }
+  unit := match[2]
+  if unit == "" {
+    unit = Days
+  }
+  return Deadline{Amount: amount, Unit: unit}, nil
+}
+
+// After returns the time at which a deadline counted from t falls due.
+// Business days skip Saturdays and Sundays.
+func (deadline Deadline) After(t time.Time) time.Time {
+  switch deadline.Unit {
+    case Minutes:
+      return t.Add(time.Duration(deadline.Amount) * time.Minute)
+    case Hours:
+      return t.Add(time.Duration(deadline.Amount) * time.Hour)
+    case Weeks:
+      return t.AddDate(0, 0, 7 * deadline.Amount)
+    case BusinessDays:
+      for n := 0; n < deadline.Amount; {
+        t = t.AddDate(0, 0, 1)
+        if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+          n++
+        }
+      }
+      return t
+  }
+  return t.AddDate(0, 0, deadline.Amount)
+}
+
+func (deadline Deadline) String() string {
+  return strconv.Itoa(deadline.Amount) + deadline.Unit
+}
+
+// Checks that absolute deadlines (deadline by Event.field) are read from an earlier event
+func (spec *Spec) CheckDeadlines() error {
+  events := []*Event{spec.CreateEvent, spec.DetachEvent, spec.DischargeEvent}
+  for i, event := range events {
+    if event.By == nil {
+      continue
+    }
+    if err := checkRefs(event.By, events[:i]); err != nil {
+      err.Message = "invalid deadline on " + event.Name + ": " + err.Message
+      return *err
+    }
+  }
+  return nil
+}
@@ -0,0 +1,66 @@
+package quark
+
+import (
+  "testing"
+  "time"
+)
+
+func TestParseDeadline(t *testing.T) {
+  tests := []struct {
+    value     string
+    deadline  Deadline
+    valid     bool
+  }{
+    {"5", Deadline{5, Days}, true},
+    {"30m", Deadline{30, Minutes}, true},
+    {"48h", Deadline{48, Hours}, true},
+    {"3d", Deadline{3, Days}, true},
+    {"10bd", Deadline{10, BusinessDays}, true},
+    {"2w", Deadline{2, Weeks}, true},
+    {"0m", Deadline{0, Minutes}, true},
+    {"", Deadline{}, false},
+    {"5y", Deadline{}, false},
+    {"-5", Deadline{}, false},
+    {"h", Deadline{}, false},
+    {"1.5d", Deadline{}, false},
+  }
+
+  for _, test := range tests {
+    deadline, err := ParseDeadline(test.value)
+    if test.valid && (err != nil || deadline != test.deadline) {
+      t.Errorf("ParseDeadline(%q) = %v, %v, expected %v", test.value, deadline, err, test.deadline)
+    } else if !test.valid && err == nil {
+      t.Errorf("ParseDeadline(%q) = %v, expected an error", test.value, deadline)
+    }
+  }
+}
+
+func TestDeadlineAfter(t *testing.T) {
+  // Friday 5 January 2018, 17:30 UTC
+  friday := time.Date(2018, time.January, 5, 17, 30, 0, 0, time.UTC)
+  tests := []struct {
+    deadline  Deadline
+    from      time.Time
+    due       time.Time
+  }{
+    {Deadline{30, Minutes}, friday, time.Date(2018, time.January, 5, 18, 0, 0, 0, time.UTC)},
+    {Deadline{48, Hours}, friday, time.Date(2018, time.January, 7, 17, 30, 0, 0, time.UTC)},
+    {Deadline{5, Days}, friday, time.Date(2018, time.January, 10, 17, 30, 0, 0, time.UTC)},
+    {Deadline{2, Weeks}, friday, time.Date(2018, time.January, 19, 17, 30, 0, 0, time.UTC)},
+    {Deadline{0, Minutes}, friday, friday},
+    // Days roll over months and leap years
+    {Deadline{3, Days}, time.Date(2020, time.February, 27, 9, 0, 0, 0, time.UTC), time.Date(2020, time.March, 1, 9, 0, 0, 0, time.UTC)},
+    // Business days skip the weekend: Friday + 1bd is Monday, + 5bd the next Friday
+    {Deadline{1, BusinessDays}, friday, time.Date(2018, time.January, 8, 17, 30, 0, 0, time.UTC)},
+    {Deadline{5, BusinessDays}, friday, time.Date(2018, time.January, 12, 17, 30, 0, 0, time.UTC)},
+    // From a Saturday, the first business day is Monday
+    {Deadline{1, BusinessDays}, time.Date(2018, time.January, 6, 12, 0, 0, 0, time.UTC), time.Date(2018, time.January, 8, 12, 0, 0, 0, time.UTC)},
+    {Deadline{10, BusinessDays}, time.Date(2018, time.January, 1, 9, 0, 0, 0, time.UTC), time.Date(2018, time.January, 15, 9, 0, 0, 0, time.UTC)},
+  }
+
+  for _, test := range tests {
+    if due := test.deadline.After(test.from); !due.Equal(test.due) {
+      t.Errorf("%v after %v = %v, expected %v", test.deadline, test.from, due, test.due)
+    }
+  }
+}
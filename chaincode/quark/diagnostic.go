@@ -0,0 +1,42 @@
+package quark
+
+import (
+  "fmt"
+  "strings"
+)
+
+// Diagnostic is an error found in spec source code, with the position it was found at
+type Diagnostic struct {
+  Pos
+  Message  string
+}
+
+// Diagnostics is the list of errors found while parsing a spec
+type Diagnostics []Diagnostic
+
+func (diag Diagnostic) Error() string {
+  return fmt.Sprintf("line %d, column %d: %s", diag.Line, diag.Column, diag.Message)
+}
+
+func (diags Diagnostics) Error() string {
+  msgs := make([]string, len(diags))
+  for i, diag := range diags {
+    msgs[i] = diag.Error()
+  }
+  return strings.Join(msgs, "\n")
+}
+
+// Format shows each error next to the offending source line, with a marker under its column
+func (diags Diagnostics) Format(source string) string {
+  lines := strings.Split(source, "\n")
+  var buf strings.Builder
+  for _, diag := range diags {
+    buf.WriteString(diag.Error() + "\n")
+    if diag.Line >= 1 && diag.Line <= len(lines) {
+      line := strings.Replace(lines[diag.Line-1], "\t", " ", -1)
+      buf.WriteString("  " + line + "\n")
+      buf.WriteString("  " + strings.Repeat(" ", diag.Column-1) + "^\n")
+    }
+  }
+  return buf.String()
+}
@@ -0,0 +1,442 @@
+package quark
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// Env maps event names to the data of every occurrence of the event so far, oldest first.
+// Field references resolve against the latest occurrence, aggregates against all of them
+type Env map[string][]map[string]interface{}
+
+// Expr is a node of a guard expression (e.g. Pay.amount >= Offer.price)
+type Expr interface {
+  Eval(env Env) (interface{}, error)
+  String() string
+}
+
+// A reference to a field of an event (e.g. Offer.price)
+type FieldRef struct {
+  Event  string
+  Field  string
+  Pos    Pos
+}
+
+// An aggregate of a field over every occurrence of an event (e.g. sum(Pay.amount))
+type Aggregate struct {
+  Func  string
+  Ref   *FieldRef
+}
+
+// Aggregate functions. Apart from count, they apply to the numeric values of a field
+var aggregateFuncs = []string{"sum", "count", "min", "max"}
+
+// A number, string or boolean literal
+type Literal struct {
+  Value  interface{}
+}
+
+// A comparison (==, !=, <, <=, >, >=) or logical (and, or) operation
+type BinaryExpr struct {
+  Op   string
+  LHS  Expr
+  RHS  Expr
+}
+
+// A logical negation
+type NotExpr struct {
+  X  Expr
+}
+
+// Fields available on every event in addition to its argument list
+var builtinFields = []string{"comID", "date", "businessDate", "debtor", "creditor"}
+
+// Parses the optional 'where' guard clause of a detach or discharge event
+func GetGuard(event *Event, p *Parser) (error) {
+  if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToLower(lit) != "where" {
+    p.unscan()
+    return nil
+  }
+  guard, err := parseOr(p)
+  if err != nil {
+    return err
+  }
+  event.Guard = guard
+  return nil
+}
+
+// Checks that the field references of every guard name an event that has occurred
+// by the time the guarded event occurs, and a field declared on that event
+func (spec *Spec) CheckGuards() error {
+  events := []*Event{spec.CreateEvent, spec.DetachEvent, spec.DischargeEvent}
+  for i, event := range events {
+    if event.Guard == nil {
+      continue
+    }
+    if err := checkRefs(event.Guard, events[:i+1]); err != nil {
+      err.Message = "invalid guard on " + event.Name + ": " + err.Message
+      return *err
+    }
+  }
+  return nil
+}
+
+// Evaluates a guard against the given events, returning whether it holds
+func EvalGuard(guard Expr, env Env) (bool, error) {
+  if guard == nil {
+    return true, nil
+  }
+  val, err := guard.Eval(env)
+  if err != nil {
+    return false, err
+  }
+  res, ok := val.(bool)
+  if !ok {
+    return false, fmt.Errorf("guard %s is not a boolean expression", guard)
+  }
+  return res, nil
+}
+
+// parseOr parses: and ("or" and)*
+func parseOr(p *Parser) (Expr, error) {
+  lhs, err := parseAnd(p)
+  if err != nil {
+    return nil, err
+  }
+  for {
+    if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToLower(lit) != "or" {
+      p.unscan()
+      return lhs, nil
+    }
+    rhs, err := parseAnd(p)
+    if err != nil {
+      return nil, err
+    }
+    lhs = &BinaryExpr{Op: "or", LHS: lhs, RHS: rhs}
+  }
+}
+
+// parseAnd parses: not ("and" not)*
+func parseAnd(p *Parser) (Expr, error) {
+  lhs, err := parseNot(p)
+  if err != nil {
+    return nil, err
+  }
+  for {
+    if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToLower(lit) != "and" {
+      p.unscan()
+      return lhs, nil
+    }
+    rhs, err := parseNot(p)
+    if err != nil {
+      return nil, err
+    }
+    lhs = &BinaryExpr{Op: "and", LHS: lhs, RHS: rhs}
+  }
+}
+
+// parseNot parses: "not" not | comparison
+func parseNot(p *Parser) (Expr, error) {
+  if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.ToLower(lit) == "not" {
+    x, err := parseNot(p)
+    if err != nil {
+      return nil, err
+    }
+    return &NotExpr{X: x}, nil
+  }
+  p.unscan()
+  return parseComparison(p)
+}
+
+// parseComparison parses: operand (op operand)?
+func parseComparison(p *Parser) (Expr, error) {
+  lhs, err := parseOperand(p)
+  if err != nil {
+    return nil, err
+  }
+  tok, lit := p.scanIgnoreWhitespace()
+  switch tok {
+    case EQ, NEQ, LT, LTE, GT, GTE:
+      rhs, err := parseOperand(p)
+      if err != nil {
+        return nil, err
+      }
+      return &BinaryExpr{Op: lit, LHS: lhs, RHS: rhs}, nil
+  }
+  p.unscan()
+  return lhs, nil
+}
+
+// parseOperand parses: "(" expr ")" | aggregate "(" Event.field ")" | Event.field | number | "string" | true | false
+func parseOperand(p *Parser) (Expr, error) {
+  tok, lit := p.scanIgnoreWhitespace()
+  switch tok {
+    case LPAREN:
+      x, err := parseOr(p)
+      if err != nil {
+        return nil, err
+      }
+      if tok, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+        return nil, p.errorf("found %q, expected ')'", lit)
+      }
+      return x, nil
+    case STRING:
+      return &Literal{Value: lit}, nil
+    case IDENT:
+      pos := p.buf.pos
+      // Field references and decimal numbers both contain a dot
+      if tok_dot, _ := p.scanIgnoreWhitespace(); tok_dot == DOT {
+        tok_field, lit_field := p.scanIgnoreWhitespace()
+        if tok_field != IDENT {
+          return nil, p.errorf("found %q, expected field name after %q", lit_field, lit + ".")
+        }
+        if num, err := strconv.ParseFloat(lit + "." + lit_field, 64); err == nil {
+          return &Literal{Value: num}, nil
+        }
+        return &FieldRef{Event: lit, Field: lit_field, Pos: pos}, nil
+      }
+      p.unscan()
+      if isAggregateFunc(lit) {
+        return parseAggregate(p, lit)
+      }
+      if num, err := strconv.ParseFloat(lit, 64); err == nil {
+        return &Literal{Value: num}, nil
+      }
+      switch strings.ToLower(lit) {
+        case "true":
+          return &Literal{Value: true}, nil
+        case "false":
+          return &Literal{Value: false}, nil
+      }
+      return nil, p.errorf("found %q, expected Event.field reference", lit)
+  }
+  return nil, p.errorf("found %q, expected guard expression", lit)
+}
+
+// parseAggregate parses the argument of an aggregate function: "(" Event.field ")"
+func parseAggregate(p *Parser, fn string) (Expr, error) {
+  if tok, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+    p.unscan()
+    return nil, p.errorf("found %q, expected '(' after %s", lit, fn)
+  }
+  arg, err := parseOperand(p)
+  if err != nil {
+    return nil, err
+  }
+  ref, ok := arg.(*FieldRef)
+  if !ok {
+    return nil, p.errorf("%s expects an Event.field reference, found %s", fn, arg)
+  }
+  if tok, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+    return nil, p.errorf("found %q, expected ')'", lit)
+  }
+  return &Aggregate{Func: strings.ToLower(fn), Ref: ref}, nil
+}
+
+func isAggregateFunc(name string) bool {
+  for _, fn := range aggregateFuncs {
+    if strings.ToLower(name) == fn {
+      return true
+    }
+  }
+  return false
+}
+
+// checkRefs checks the field references of an expression against a list of events
+func checkRefs(expr Expr, events []*Event) *Diagnostic {
+  switch x := expr.(type) {
+    case *BinaryExpr:
+      if err := checkRefs(x.LHS, events); err != nil {
+        return err
+      }
+      return checkRefs(x.RHS, events)
+    case *NotExpr:
+      return checkRefs(x.X, events)
+    case *Aggregate:
+      return checkRefs(x.Ref, events)
+    case *FieldRef:
+      for _, event := range events {
+        if event.Name != x.Event {
+          continue
+        }
+        for _, arg := range event.Args {
+          if arg.Name == x.Field && arg.Name != "deadline" {
+            return nil
+          }
+        }
+        if isBuiltinField(x.Field) {
+          return nil
+        }
+        return &Diagnostic{Pos: x.Pos, Message: fmt.Sprintf("%s has no field %q", x.Event, x.Field)}
+      }
+      return &Diagnostic{Pos: x.Pos, Message: x.Event + " is not an earlier event of this spec"}
+  }
+  return nil
+}
+
+// Eval resolves the referenced field of the latest occurrence of the event, returning its value
+// as a number where possible
+func (ref *FieldRef) Eval(env Env) (interface{}, error) {
+  occurrences := env[ref.Event]
+  if len(occurrences) == 0 || occurrences[len(occurrences) - 1] == nil {
+    return nil, fmt.Errorf("no %s event has occurred", ref.Event)
+  }
+  return ref.resolve(occurrences[len(occurrences) - 1])
+}
+
+// Resolves the referenced field in the data of one occurrence of the event
+func (ref *FieldRef) resolve(data map[string]interface{}) (interface{}, error) {
+  val, ok := data[ref.Field]
+  if !ok {
+    return nil, fmt.Errorf("%s event has no field %q", ref.Event, ref.Field)
+  }
+  if str, ok := val.(string); ok {
+    if num, err := strconv.ParseFloat(str, 64); err == nil {
+      return num, nil
+    }
+  }
+  return val, nil
+}
+
+func (ref *FieldRef) String() string { return ref.Event + "." + ref.Field }
+
+// Eval applies the aggregate function to the field of every occurrence of the event. The count and
+// sum of an event that hasn't occurred are 0, while its min and max are an error
+func (agg *Aggregate) Eval(env Env) (interface{}, error) {
+  values := []float64{}
+  for _, data := range env[agg.Ref.Event] {
+    if data == nil {
+      continue
+    }
+    val, err := agg.Ref.resolve(data)
+    if err != nil {
+      return nil, err
+    }
+    if agg.Func == "count" {
+      values = append(values, 0)
+      continue
+    }
+    num, ok := val.(float64)
+    if !ok {
+      return nil, fmt.Errorf("%s is not a number in every %s event, found %v", agg.Ref, agg.Ref.Event, val)
+    }
+    values = append(values, num)
+  }
+
+  switch agg.Func {
+    case "count":
+      return float64(len(values)), nil
+    case "sum":
+      total := 0.0
+      for _, num := range values {
+        total += num
+      }
+      return total, nil
+  }
+  if len(values) == 0 {
+    return nil, fmt.Errorf("no %s event has occurred", agg.Ref.Event)
+  }
+  res := values[0]
+  for _, num := range values[1:] {
+    if (agg.Func == "min" && num < res) || (agg.Func == "max" && num > res) {
+      res = num
+    }
+  }
+  return res, nil
+}
+
+func (agg *Aggregate) String() string { return agg.Func + "(" + agg.Ref.String() + ")" }
+
+// Eval returns the literal value
+func (lit *Literal) Eval(env Env) (interface{}, error) { return lit.Value, nil }
+
+func (lit *Literal) String() string {
+  if str, ok := lit.Value.(string); ok {
+    return strconv.Quote(str)
+  }
+  return fmt.Sprint(lit.Value)
+}
+
+// Eval negates the boolean operand
+func (not *NotExpr) Eval(env Env) (interface{}, error) {
+  val, err := not.X.Eval(env)
+  if err != nil {
+    return nil, err
+  }
+  b, ok := val.(bool)
+  if !ok {
+    return nil, fmt.Errorf("cannot apply 'not' to %s", not.X)
+  }
+  return !b, nil
+}
+
+func (not *NotExpr) String() string { return "not " + not.X.String() }
+
+// Eval applies the operator. The logical operators only evaluate their right operand if the left one doesn't
+// decide the result, so 'X or Y' holds once X does even if Y refers to an event that hasn't occurred yet.
+// Numbers compare numerically, anything else compares as strings
+func (bin *BinaryExpr) Eval(env Env) (interface{}, error) {
+  lhs, err := bin.LHS.Eval(env)
+  if err != nil {
+    return nil, err
+  }
+
+  // Logical operators
+  if bin.Op == "and" || bin.Op == "or" {
+    l, ok := lhs.(bool)
+    if !ok {
+      return nil, fmt.Errorf("cannot apply %q to %s and %s", bin.Op, bin.LHS, bin.RHS)
+    }
+    if l == (bin.Op == "or") {
+      return l, nil
+    }
+    rhs, err := bin.RHS.Eval(env)
+    if err != nil {
+      return nil, err
+    }
+    r, ok := rhs.(bool)
+    if !ok {
+      return nil, fmt.Errorf("cannot apply %q to %s and %s", bin.Op, bin.LHS, bin.RHS)
+    }
+    return r, nil
+  }
+
+  rhs, err := bin.RHS.Eval(env)
+  if err != nil {
+    return nil, err
+  }
+
+  // Comparison operators
+  var cmp int
+  l, lok := lhs.(float64)
+  r, rok := rhs.(float64)
+  if lok && rok {
+    if l < r {
+      cmp = -1
+    } else if l > r {
+      cmp = 1
+    }
+  } else {
+    cmp = strings.Compare(fmt.Sprint(lhs), fmt.Sprint(rhs))
+  }
+  switch bin.Op {
+    case "==":
+      return cmp == 0, nil
+    case "!=":
+      return cmp != 0, nil
+    case "<":
+      return cmp < 0, nil
+    case "<=":
+      return cmp <= 0, nil
+    case ">":
+      return cmp > 0, nil
+    case ">=":
+      return cmp >= 0, nil
+  }
+  return nil, fmt.Errorf("unknown operator %q", bin.Op)
+}
+
+func (bin *BinaryExpr) String() string {
+  return "(" + bin.LHS.String() + " " + bin.Op + " " + bin.RHS.String() + ")"
+}
@@ -0,0 +1,95 @@
+package quark
+
+import (
+  "strings"
+  "testing"
+)
+
+// Parses a guard on the discharge event of a spec with Offer, Pay and Delivery events
+func parseGuard(t *testing.T, guard string) Expr {
+  spec, diags := Parse("spec S d to c\n  create Offer [item,price:decimal]\n  detach Pay [amount:decimal]" +
+    " deadline=5\n  discharge Delivery [courier] where " + guard + " deadline=5")
+  if diags != nil {
+    t.Fatalf("guard %s: %v", guard, diags)
+  }
+  return spec.DischargeEvent.Guard
+}
+
+func TestEvalGuard(t *testing.T) {
+  env := Env{
+    "Offer": {{"item": "book", "price": "30"}},
+    "Pay": {{"amount": "10"}, {"amount": "25.5"}},
+    "Delivery": {{"courier": "DHL"}},
+  }
+  tests := []struct {
+    guard  string
+    holds  bool
+  }{
+    {`Pay.amount >= Offer.price`, false},
+    {`Pay.amount < Offer.price`, true},
+    {`sum(Pay.amount) >= Offer.price`, true},
+    {`sum(Pay.amount) == 35.5`, true},
+    {`count(Pay.amount) == 2`, true},
+    {`min(Pay.amount) == 10 and max(Pay.amount) == 25.5`, true},
+    {`Delivery.courier == "DHL"`, true},
+    {`Delivery.courier != "DHL"`, false},
+    {`not (Delivery.courier == "UPS")`, true},
+    {`Delivery.courier == "UPS" or Offer.item == "book"`, true},
+    {`Offer.price > 4`, true},  // compared as numbers, not as the strings "30" and "4"
+    {`(Offer.price > 100 or Pay.amount > 20) and Offer.item == "book"`, true},
+  }
+
+  for _, test := range tests {
+    holds, err := EvalGuard(parseGuard(t, test.guard), env)
+    if err != nil {
+      t.Errorf("%s: %v", test.guard, err)
+    } else if holds != test.holds {
+      t.Errorf("%s = %v, expected %v", test.guard, holds, test.holds)
+    }
+  }
+}
+
+func TestEvalGuardErrors(t *testing.T) {
+  env := Env{
+    "Offer": {{"item": "book", "price": "30"}},
+    "Delivery": {{"date": "Mon Jan  8 10:00:00 2018"}},  // without the courier it declares
+  }
+  tests := []struct {
+    guard  string
+    err    string  // a part of the error expected
+  }{
+    {`Pay.amount >= Offer.price`, "no Pay event has occurred"},
+    {`max(Pay.amount) > 0`, "no Pay event has occurred"},
+    {`sum(Offer.item) > 0`, "is not a number"},
+    {`Delivery.courier == "DHL"`, "Delivery event has no field \"courier\""},
+  }
+
+  for _, test := range tests {
+    _, err := EvalGuard(parseGuard(t, test.guard), env)
+    if err == nil || !strings.Contains(err.Error(), test.err) {
+      t.Errorf("%s: got error %v, expected %q", test.guard, err, test.err)
+    }
+  }
+}
+
+func TestEvalGuardShortCircuits(t *testing.T) {
+  // The right operand refers to an event that hasn't occurred, so only evaluates if the left one doesn't decide
+  env := Env{"Offer": {{"item": "book", "price": "30"}}}
+  tests := []struct {
+    guard  string
+    holds  bool
+  }{
+    {`Offer.item == "book" or Pay.amount > 0`, true},
+    {`Offer.item == "pen" and Pay.amount > 0`, false},
+    {`sum(Pay.amount) == 0 and count(Pay.amount) == 0`, true},
+  }
+
+  for _, test := range tests {
+    holds, err := EvalGuard(parseGuard(t, test.guard), env)
+    if err != nil {
+      t.Errorf("%s: %v", test.guard, err)
+    } else if holds != test.holds {
+      t.Errorf("%s = %v, expected %v", test.guard, holds, test.holds)
+    }
+  }
+}
@@ -4,11 +4,8 @@ import (
 	"strings"
 )
 
-// Parses a commitment specification and returns a parsed spec as a Go Struct
-func Parse(comSpec string) (spec *Spec, err error) {
-  if spec, err := NewParser(strings.NewReader(comSpec)).Parse(); err != nil {
-    return nil, err
-	} else {
-    return spec, nil
-  }
-}
\ No newline at end of file
+// Parses a commitment specification and returns a parsed spec as a Go Struct,
+// or every error found in the source
+func Parse(comSpec string) (spec *Spec, diags Diagnostics) {
+  return NewParser(strings.NewReader(comSpec)).Parse()
+}
@@ -3,6 +3,7 @@ package quark
 import (
   "fmt"
   "io"
+  "strings"
 )
 
 // Spec represents a commitment specification
@@ -22,24 +23,47 @@ type Constraint struct {
 
 // An event (with a name such as Offer, Pay) + argument list
 type Event struct {
-  Name   string
-  Args   []Arg
+  Name      string
+  Args      []Arg
+  Guard     Expr       // Optional 'where' clause that must hold for the event to count (detach and discharge only)
+  Deadline  Deadline   // Time allowed for the event to occur (detach and discharge only)
+  Anchor    string     // Event the deadline is measured from (create or detach), empty for absolute deadlines
+  By        *FieldRef  // Field of an earlier event holding an absolute deadline date
 }
 
+// Events a relative deadline can be measured from
+const (
+  AfterCreate = "create"
+  AfterDetach = "detach"
+)
+
 // Data field inside the event argument list
 type Arg struct {
-  Name   string
-  Value  string
+  Name     string
+  Value    string
+  Type     string    // Argument type (string, int, decimal, bool or enum), string if not annotated
+  Options  []string  // Allowed values of an enum argument
 }
 
+// Argument types supported in event argument lists
+const (
+  TypeString  = "string"
+  TypeInt     = "int"
+  TypeDecimal = "decimal"
+  TypeBool    = "bool"
+  TypeEnum    = "enum"
+)
+
 // Parser represents a parser.
 type Parser struct {
   s   *Scanner
   buf struct {
     tok Token  // last read token
     lit string // last read literal
+    pos Pos    // position of last read token
     n   int    // buffer size (max=1)
   }
+  diagnostics Diagnostics // errors reported so far
 }
 
 // Adds an argument to the Args slice in the Event struct
@@ -48,69 +72,122 @@ func (event *Event) AddArg(arg Arg) []Arg {
   return event.Args
 }
 
-// Parse parses a spec
-func (p *Parser) Parse() (*Spec, error) {
-  com := &Spec{}
+// Parse parses a spec. Parsing recovers from errors at the next clause
+// so that every error in the source is reported, not just the first.
+func (p *Parser) Parse() (*Spec, Diagnostics) {
+  com := &Spec{
+    Constraint: &Constraint{},
+    CreateEvent: &Event{},
+    DetachEvent: &Event{},
+    DischargeEvent: &Event{},
+  }
+
+  // Obtain 'spec' statement with name, debtor and creditor
+  if err := GetConstraint(com.Constraint, p); err != nil {
+    p.report(err)
+    p.skipTo(CREATE, DETACH, DISCHARGE)
+  }
+
+  // Obtain 'create' statement + args
+  if err := NewEvent(CREATE, com.CreateEvent, p); err != nil {
+    p.report(err)
+    p.skipTo(DETACH, DISCHARGE)
+  }
+
+  // Obtain 'detach' statement + args + guard + deadline
+  if err := GetClause(DETACH, com.DetachEvent, p); err != nil {
+    p.report(err)
+    p.skipTo(DISCHARGE)
+  }
+
+  // Obtain 'discharge' statement + args + guard + deadline
+  if err := GetClause(DISCHARGE, com.DischargeEvent, p); err != nil {
+    p.report(err)
+    p.skipTo()
+  }
+
+  // Nothing may follow the discharge clause
+  if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+    p.report(p.errorf("found %q, expected end of spec", lit))
+  }
+
+  // Guards and deadlines may only refer to events that have already occurred
+  if len(p.diagnostics) == 0 {
+    if err := com.CheckGuards(); err != nil {
+      p.report(err)
+    }
+    if err := com.CheckDeadlines(); err != nil {
+      p.report(err)
+    }
+  }
+
+  if len(p.diagnostics) > 0 {
+    return nil, p.diagnostics
+  }
+
+  // Return the successfully parsed statement
+  return com, nil
+}
 
+// Parses the 'spec' statement: spec SPEC_NAME DEBTOR to CREDITOR
+func GetConstraint(con *Constraint, p *Parser) (error) {
   // First token should be the "spec" keyword.
   if tok, lit := p.scanIgnoreWhitespace(); tok != SPEC {
-    return nil, fmt.Errorf("found %q, expected 'spec'", lit)
+    return p.errorf("found %q, expected 'spec'", lit)
   }
 
   // Get spec name
-  com.Constraint = &Constraint{}
-  tok, lit := p.scanIgnoreWhitespace();
-  if tok == IDENT {
-    com.Constraint.Name = lit
+  if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT {
+    con.Name = lit
   } else {
-    return nil, fmt.Errorf("found %q, expected specification name", lit)
+    return p.errorf("found %q, expected specification name", lit)
   }
 
   // Get Debtor/From identifier
   if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT {
-    com.Constraint.Debtor = lit
+    con.Debtor = lit
   } else {
-    return nil, fmt.Errorf("found %q, expected debtor identifier", lit)
+    return p.errorf("found %q, expected debtor identifier", lit)
   }
 
   // Next we should see the "TO" keyword.
   if tok, lit := p.scanIgnoreWhitespace(); tok != TO {
-    return nil, fmt.Errorf("found %q, expected 'to'", lit)
+    return p.errorf("found %q, expected 'to'", lit)
   }
 
   // Get Creditor/To identifier
   if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT {
-    com.Constraint.Creditor = lit
+    con.Creditor = lit
   } else {
-    return nil, fmt.Errorf("found %q, expected creditor identifier", lit)
+    return p.errorf("found %q, expected creditor identifier", lit)
   }
+  return nil
+}
 
-  // Obtain 'create' statement + args
-  com.CreateEvent = &Event{}
-  if err := NewEvent(CREATE, com.CreateEvent, p); err != nil {
-    return nil, err
+// Parses a detach or discharge clause: the event + args, optional guard and deadline
+func GetClause(evname Token, event *Event, p *Parser) (error) {
+  if err := NewEvent(evname, event, p); err != nil {
+    return err
   }
-
-  // Obtain 'detach' statement + args + deadline
-  com.DetachEvent = &Event{}
-  if err := NewEvent(DETACH, com.DetachEvent, p); err != nil {
-    return nil, err
+  if err := GetGuard(event, p); err != nil {
+    return err
   }
-  if err := GetDeadline(com.DetachEvent, p); err != nil {
-    return nil, err
+  if err := GetDeadline(event, p); err != nil {
+    return err
   }
 
-  // Obtain 'discharge' statement + args + deadline
-  com.DischargeEvent = &Event{}
-  if err := NewEvent(DISCHARGE, com.DischargeEvent, p); err != nil {
-    return nil, err
+  // Relative deadlines are measured from the previous event unless anchored explicitly
+  if evname == DETACH && event.Anchor == AfterDetach {
+    return p.errorf("detach deadline can't be measured after detach, expected 'after create'")
   }
-  if err := GetDeadline(com.DischargeEvent, p); err != nil {
-    return nil, err
+  if event.Anchor == "" && event.By == nil {
+    if evname == DETACH {
+      event.Anchor = AfterCreate
+    } else {
+      event.Anchor = AfterDetach
+    }
   }
-
-  // Return the successfully parsed statement
-  return com, nil
+  return nil
 }
 
 // Parses an event found in the spec source code
@@ -120,10 +197,10 @@ func NewEvent(evname Token, event *Event, p *Parser) (error) {
     if tok_ev == IDENT {
       event.Name = lit_ev
     } else {
-      return fmt.Errorf("found %q, expected event name for '%s'", lit_ev, evname)
+      return p.errorf("found %q, expected event name for '%s'", lit_ev, lit)
     }
   } else {
-    return fmt.Errorf("found %q, expected create/detach/discharge", lit)
+    return p.errorf("found %q, expected create/detach/discharge", lit)
   }
   // Get arguments (optional) for event fields
   if err := GetArgs(event, p); err != nil {
@@ -136,18 +213,29 @@ func NewEvent(evname Token, event *Event, p *Parser) (error) {
 func GetArgs(event *Event, p *Parser) (error) {
   // Detect left bracket to get arguments
   if tok, lit := p.scanIgnoreWhitespace(); tok != LBRACKET {
-    return fmt.Errorf("found %q, expected '['", lit)
+    return p.errorf("found %q, expected '['", lit)
   }
   // Loop over all our comma-delimited fields for this event
   for {
     // Read a field + add arg name
     tok, lit := p.scanIgnoreWhitespace()
     if tok != IDENT {
-      return fmt.Errorf("found %q, expected field", lit)
+      return p.errorf("found %q, expected field", lit)
     }
-    event.AddArg(Arg{
+    arg := Arg{
       Name: lit,
-    })
+      Type: TypeString,
+    }
+
+    // Detect optional type annotation (e.g. price:decimal)
+    if tok, _ := p.scanIgnoreWhitespace(); tok == COLON {
+      if err := GetArgType(&arg, p); err != nil {
+        return err
+      }
+    } else {
+      p.unscan()
+    }
+    event.AddArg(arg)
 
     // Detect close bracket
     if tok, _ := p.scanIgnoreWhitespace(); tok == RBRACKET {
@@ -160,35 +248,123 @@ func GetArgs(event *Event, p *Parser) (error) {
     if tok, lit := p.scanIgnoreWhitespace(); tok == COMMA {
       continue
     } else {
-      return fmt.Errorf("found %q, expected ',' or ']'", lit)
+      return p.errorf("found %q, expected ',' or ']'", lit)
     }
   }
   return nil
 }
 
-// Obtains the deadline value associated with the detach and discharge clauses
-func GetDeadline(event *Event, p *Parser) (error) {
+// Parses the type annotation of an argument, including the option list of enums
+func GetArgType(arg *Arg, p *Parser) (error) {
   tok, lit := p.scanIgnoreWhitespace()
   if tok != IDENT {
-    return fmt.Errorf("found %q, expected field", lit)
-  }
-  // Detect possible value associated with arg
-  tok_eq, _ := p.scanIgnoreWhitespace()
-  if tok_eq == EQUALS {
-    tok_val, lit_val := p.scanIgnoreWhitespace()
-    if tok_val == IDENT {
-      // Add arg name with associated arg value
-      event.AddArg(Arg{
-        Name: lit,
-        Value: lit_val,
-      })
-    } else {
-      return fmt.Errorf("found %q, expected value for %q when using '='", lit_val, lit)
+    return p.errorf("found %q, expected type for %q", lit, arg.Name)
+  }
+  switch lit {
+    case TypeString, TypeInt, TypeDecimal, TypeBool:
+      arg.Type = lit
+      return nil
+    case TypeEnum:
+      arg.Type = lit
+    default:
+      return p.errorf("found %q, expected string, int, decimal, bool or enum type for %q", lit, arg.Name)
+  }
+
+  // Enums list their allowed values (e.g. enum(Good,Damaged))
+  if tok, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+    return p.errorf("found %q, expected '(' after enum", lit)
+  }
+  for {
+    tok, lit := p.scanIgnoreWhitespace()
+    if tok != IDENT {
+      return p.errorf("found %q, expected enum value for %q", lit, arg.Name)
+    }
+    arg.Options = append(arg.Options, lit)
+
+    if tok, lit := p.scanIgnoreWhitespace(); tok == RPAREN {
+      break
+    } else if tok != COMMA {
+      return p.errorf("found %q, expected ',' or ')'", lit)
+    }
+  }
+  return nil
+}
+
+// Obtains the deadline value associated with the detach and discharge clauses.
+// Deadlines are relative (deadline=5, optionally followed by 'after create' or
+// 'after detach') or absolute, read from a field of an earlier event (deadline by Offer.expiry).
+func GetDeadline(event *Event, p *Parser) (error) {
+  tok, lit := p.scanIgnoreWhitespace()
+  if tok != IDENT || lit != "deadline" {
+    return p.errorf("found %q, expected 'deadline'", lit)
+  }
+
+  // Absolute deadline
+  if tok, word := p.scanIgnoreWhitespace(); tok == IDENT && strings.ToLower(word) == "by" {
+    ref, err := GetFieldRef(p)
+    if err != nil {
+      return err
     }
+    event.By = ref
+    event.AddArg(Arg{
+      Name: lit,
+      Value: ref.String(),
+    })
+    return nil
+  } else if tok != EQUALS {
+    return p.errorf("found %q, expected '=' or 'by' after 'deadline'", p.buf.lit)
+  }
+
+  tok_val, lit_val := p.scanIgnoreWhitespace()
+  if tok_val != IDENT {
+    return p.errorf("found %q, expected value for %q when using '='", lit_val, lit)
+  }
+  deadline, err := ParseDeadline(lit_val)
+  if err != nil {
+    return p.errorf("%v", err)
+  }
+  event.Deadline = deadline
+
+  // Add arg name with associated arg value
+  event.AddArg(Arg{
+    Name: lit,
+    Value: lit_val,
+  })
+
+  // Detect optional anchor the deadline is measured from
+  if tok, word := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToLower(word) != "after" {
+    p.unscan()
+    return nil
+  }
+  switch tok, lit := p.scanIgnoreWhitespace(); tok {
+    case CREATE:
+      event.Anchor = AfterCreate
+    case DETACH:
+      event.Anchor = AfterDetach
+    default:
+      return p.errorf("found %q, expected 'create' or 'detach' after 'after'", lit)
   }
   return nil
 }
 
+// Parses a reference to a field of an event (e.g. Offer.expiry)
+func GetFieldRef(p *Parser) (*FieldRef, error) {
+  tok, lit := p.scanIgnoreWhitespace()
+  if tok != IDENT {
+    return nil, p.errorf("found %q, expected Event.field reference", lit)
+  }
+  ref := &FieldRef{Event: lit, Pos: p.buf.pos}
+  if tok, lit := p.scanIgnoreWhitespace(); tok != DOT {
+    return nil, p.errorf("found %q, expected '.' after %q", lit, ref.Event)
+  }
+  tok, lit = p.scanIgnoreWhitespace()
+  if tok != IDENT {
+    return nil, p.errorf("found %q, expected field name after %q", lit, ref.Event + ".")
+  }
+  ref.Field = lit
+  return ref, nil
+}
+
 // NewParser returns a new instance of Parser.
 func NewParser(r io.Reader) *Parser {
   return &Parser{s: NewScanner(r)}
@@ -204,10 +380,10 @@ func (p *Parser) scan() (tok Token, lit string) {
   }
 
   // Otherwise read the next token from the scanner.
-  tok, lit = p.s.Scan()
+  tok, lit, pos := p.s.Scan()
 
   // Save it to the buffer in case we unscan later.
-  p.buf.tok, p.buf.lit = tok, lit
+  p.buf.tok, p.buf.lit, p.buf.pos = tok, lit, pos
   return
 }
 
@@ -224,3 +400,43 @@ func (p *Parser) scanIgnoreWhitespace() (tok Token, lit string) {
 func (p *Parser) unscan() {
   p.buf.n = 1
 }
+
+// errorf returns a diagnostic positioned at the last read token.
+func (p *Parser) errorf(format string, args ...interface{}) Diagnostic {
+  return Diagnostic{Pos: p.buf.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// report records an error, positioned at the last read token unless it already has a position.
+// Only the first error at a position is kept, as later ones (e.g. at EOF) are knock-on errors.
+func (p *Parser) report(err error) {
+  diag, ok := err.(Diagnostic)
+  if !ok {
+    diag = Diagnostic{Pos: p.buf.pos, Message: err.Error()}
+  }
+  if n := len(p.diagnostics); n > 0 && p.diagnostics[n-1].Pos == diag.Pos {
+    return
+  }
+  p.diagnostics = append(p.diagnostics, diag)
+}
+
+// skipTo recovers from an error by skipping tokens until one of the given
+// clause keywords (or EOF), which is left on the buffer to be parsed next.
+func (p *Parser) skipTo(toks ...Token) {
+  tok := p.buf.tok
+  if p.buf.n != 0 {
+    tok, _ = p.scan()
+  }
+  for {
+    if tok == EOF {
+      p.unscan()
+      return
+    }
+    for _, t := range toks {
+      if tok == t {
+        p.unscan()
+        return
+      }
+    }
+    tok, _ = p.scan()
+  }
+}
@@ -0,0 +1,182 @@
+package quark
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestParseExamples(t *testing.T) {
+  files, err := filepath.Glob(filepath.Join("examples", "*.quark"))
+  if err != nil || len(files) == 0 {
+    t.Fatalf("no example specs found: %v", err)
+  }
+  for _, file := range files {
+    source, err := ioutil.ReadFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if _, diags := Parse(string(source)); diags != nil {
+      t.Errorf("%s: %v", file, diags)
+    }
+  }
+}
+
+func TestParse(t *testing.T) {
+  tests := []struct {
+    name      string
+    source    string
+    check     func(t *testing.T, spec *Spec)
+  }{
+    {
+      name: "constraint and events",
+      source: "spec SellItem dID to cID\n  create Offer [item,price:decimal]\n  detach Pay [amount] deadline=5\n  discharge Delivery [courier] deadline=10",
+      check: func(t *testing.T, spec *Spec) {
+        if spec.Constraint.Name != "SellItem" || spec.Constraint.Debtor != "dID" || spec.Constraint.Creditor != "cID" {
+          t.Errorf("constraint = %+v", spec.Constraint)
+        }
+        if spec.CreateEvent.Name != "Offer" || spec.DetachEvent.Name != "Pay" || spec.DischargeEvent.Name != "Delivery" {
+          t.Errorf("events = %s, %s, %s", spec.CreateEvent.Name, spec.DetachEvent.Name, spec.DischargeEvent.Name)
+        }
+        if len(spec.CreateEvent.Args) != 2 || spec.CreateEvent.Args[0].Type != TypeString || spec.CreateEvent.Args[1].Type != TypeDecimal {
+          t.Errorf("create args = %+v", spec.CreateEvent.Args)
+        }
+        if spec.DetachEvent.Deadline != (Deadline{Amount: 5, Unit: Days}) || spec.DischargeEvent.Deadline != (Deadline{Amount: 10, Unit: Days}) {
+          t.Errorf("deadlines = %v, %v", spec.DetachEvent.Deadline, spec.DischargeEvent.Deadline)
+        }
+      },
+    },
+    {
+      name: "enum argument",
+      source: "spec S d to c\n  create Offer [quality:enum(Good,Damaged)]\n  detach Pay [amount] deadline=5\n  discharge Delivery [courier] deadline=5",
+      check: func(t *testing.T, spec *Spec) {
+        arg := spec.CreateEvent.Args[0]
+        if arg.Type != TypeEnum || strings.Join(arg.Options, ",") != "Good,Damaged" {
+          t.Errorf("quality = %+v", arg)
+        }
+      },
+    },
+    {
+      name: "deadline units and anchors",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount] deadline=48h after create\n  discharge Delivery [courier] deadline=10bd after detach",
+      check: func(t *testing.T, spec *Spec) {
+        if spec.DetachEvent.Deadline != (Deadline{Amount: 48, Unit: Hours}) || spec.DetachEvent.Anchor != AfterCreate {
+          t.Errorf("detach deadline = %v after %q", spec.DetachEvent.Deadline, spec.DetachEvent.Anchor)
+        }
+        if spec.DischargeEvent.Deadline != (Deadline{Amount: 10, Unit: BusinessDays}) || spec.DischargeEvent.Anchor != AfterDetach {
+          t.Errorf("discharge deadline = %v after %q", spec.DischargeEvent.Deadline, spec.DischargeEvent.Anchor)
+        }
+      },
+    },
+    {
+      name: "absolute deadline",
+      source: "spec S d to c\n  create Offer [item,expiry]\n  detach Pay [amount] deadline by Offer.expiry\n  discharge Delivery [courier] deadline=5",
+      check: func(t *testing.T, spec *Spec) {
+        if spec.DetachEvent.By == nil || spec.DetachEvent.By.String() != "Offer.expiry" {
+          t.Errorf("detach deadline by %v", spec.DetachEvent.By)
+        }
+      },
+    },
+    {
+      name: "guards",
+      source: "spec S d to c\n  create Offer [item,price:decimal]\n  detach Pay [amount:decimal] where sum(Pay.amount) >= Offer.price deadline=5\n  discharge Delivery [courier] where not (Delivery.courier == \"none\") deadline=5",
+      check: func(t *testing.T, spec *Spec) {
+        if spec.DetachEvent.Guard == nil || spec.DetachEvent.Guard.String() != "(sum(Pay.amount) >= Offer.price)" {
+          t.Errorf("detach guard = %v", spec.DetachEvent.Guard)
+        }
+        if spec.DischargeEvent.Guard == nil || spec.DischargeEvent.Guard.String() != "not (Delivery.courier == \"none\")" {
+          t.Errorf("discharge guard = %v", spec.DischargeEvent.Guard)
+        }
+      },
+    },
+    {
+      name: "keywords as argument names",
+      source: "spec S d to c\n  create Offer [item,where,after]\n  detach Pay [by] where Pay.by == Offer.where deadline=5 after create\n  discharge Delivery [courier] deadline=5",
+      check: func(t *testing.T, spec *Spec) {
+        if len(spec.CreateEvent.Args) != 3 || spec.CreateEvent.Args[1].Name != "where" || spec.CreateEvent.Args[2].Name != "after" {
+          t.Errorf("create args = %+v", spec.CreateEvent.Args)
+        }
+        if spec.DetachEvent.Guard == nil || spec.DetachEvent.Guard.String() != "(Pay.by == Offer.where)" || spec.DetachEvent.Anchor != AfterCreate {
+          t.Errorf("detach guard = %v, deadline after %q", spec.DetachEvent.Guard, spec.DetachEvent.Anchor)
+        }
+      },
+    },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      spec, diags := Parse(test.source)
+      if diags != nil {
+        t.Fatalf("unexpected errors: %v", diags)
+      }
+      test.check(t, spec)
+    })
+  }
+}
+
+func TestParseErrors(t *testing.T) {
+  tests := []struct {
+    name    string
+    source  string
+    errors  []string  // a part of each error expected, in order
+  }{
+    {
+      name: "missing spec keyword",
+      source: "SellItem d to c\n  create Offer [item]\n  detach Pay [amount] deadline=5\n  discharge Delivery [courier] deadline=5",
+      errors: []string{"line 1"},
+    },
+    {
+      name: "missing deadline",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount]\n  discharge Delivery [courier] deadline=5",
+      errors: []string{"expected 'deadline'"},
+    },
+    {
+      name: "unknown deadline unit",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount] deadline=5y\n  discharge Delivery [courier] deadline=5",
+      errors: []string{"line 3, column 32: invalid deadline \"5y\""},
+    },
+    {
+      name: "an error in every clause",
+      source: "spec S d to c\n  create Offer [item,price:money]\n  detach Pay [amount] deadline=5y\n  discharge Delivery [courier] deadline=fortnight",
+      errors: []string{"line 2", "line 3", "line 4"},
+    },
+    {
+      name: "guard on a later event",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount] where Delivery.courier == \"DHL\" deadline=5\n  discharge Delivery [courier] deadline=5",
+      errors: []string{"Delivery"},
+    },
+    {
+      name: "guard on an undeclared field",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount] where Offer.colour == \"red\" deadline=5\n  discharge Delivery [courier] deadline=5",
+      errors: []string{"Offer has no field \"colour\""},
+    },
+    {
+      name: "absolute deadline from a later event",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount] deadline by Delivery.date\n  discharge Delivery [courier] deadline=5",
+      errors: []string{"invalid deadline on Pay"},
+    },
+    {
+      name: "text after the discharge clause",
+      source: "spec S d to c\n  create Offer [item]\n  detach Pay [amount] deadline=5\n  discharge Delivery [courier] deadline=5\n  release",
+      errors: []string{"expected end of spec"},
+    },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      spec, diags := Parse(test.source)
+      if diags == nil {
+        t.Fatalf("parsed %+v, expected errors", spec)
+      }
+      if len(diags) < len(test.errors) {
+        t.Fatalf("got %d errors, expected %d: %v", len(diags), len(test.errors), diags)
+      }
+      for i, expected := range test.errors {
+        if !strings.Contains(diags[i].Error(), expected) {
+          t.Errorf("error %d = %q, expected it to contain %q", i, diags[i].Error(), expected)
+        }
+      }
+    })
+  }
+}
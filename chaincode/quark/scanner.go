@@ -9,16 +9,25 @@ import (
 
 // Scanner represents a lexical scanner.
 type Scanner struct {
-  r *bufio.Reader
+  r    *bufio.Reader
+  pos  Pos  // position of the next rune
+  prev Pos  // position of the last read rune, restored by unread
 }
 
 // NewScanner returns a new instance of Scanner.
 func NewScanner(r io.Reader) *Scanner {
-  return &Scanner{r: bufio.NewReader(r)}
+  return &Scanner{r: bufio.NewReader(r), pos: Pos{Line: 1, Column: 1}}
 }
 
-// Scan returns the next token and literal value.
-func (s *Scanner) Scan() (tok Token, lit string) {
+// Scan returns the next token, its literal value and its position.
+func (s *Scanner) Scan() (tok Token, lit string, pos Pos) {
+  pos = s.pos
+  tok, lit = s.scanToken()
+  return tok, lit, pos
+}
+
+// scanToken reads the next token and literal value.
+func (s *Scanner) scanToken() (tok Token, lit string) {
   // Read the next rune.
   ch := s.read()
 
@@ -42,9 +51,40 @@ func (s *Scanner) Scan() (tok Token, lit string) {
     case ']':
       return RBRACKET, string(ch)
     case '=':
+      if s.read() == '=' {
+        return EQ, "=="
+      }
+      s.unread()
       return EQUALS, string(ch)
     case ',':
       return COMMA, string(ch)
+    case ':':
+      return COLON, string(ch)
+    case '(':
+      return LPAREN, string(ch)
+    case ')':
+      return RPAREN, string(ch)
+    case '.':
+      return DOT, string(ch)
+    case '<':
+      if s.read() == '=' {
+        return LTE, "<="
+      }
+      s.unread()
+      return LT, string(ch)
+    case '>':
+      if s.read() == '=' {
+        return GTE, ">="
+      }
+      s.unread()
+      return GT, string(ch)
+    case '!':
+      if s.read() == '=' {
+        return NEQ, "!="
+      }
+      s.unread()
+    case '"':
+      return s.scanString()
   }
 
   return ILLEGAL, string(ch)
@@ -109,18 +149,44 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
   return IDENT, buf.String()
 }
 
+// scanString consumes a double quoted string, returning its contents without the quotes.
+func (s *Scanner) scanString() (tok Token, lit string) {
+  var buf bytes.Buffer
+  for {
+    if ch := s.read(); ch == eof || ch == '\n' {
+      return ILLEGAL, "\"" + buf.String()
+    } else if ch == '"' {
+      break
+    } else {
+      buf.WriteRune(ch)
+    }
+  }
+  return STRING, buf.String()
+}
+
 // read reads the next rune from the buffered reader.
 // Returns the rune(0) if an error occurs (or io.EOF is returned).
+// Tracks the line and column of the next rune.
 func (s *Scanner) read() rune {
+  s.prev = s.pos
   ch, _, err := s.r.ReadRune()
   if err != nil {
     return eof
   }
+  if ch == '\n' {
+    s.pos.Line++
+    s.pos.Column = 1
+  } else {
+    s.pos.Column++
+  }
   return ch
 }
 
 // unread places the previously read rune back on the reader.
-func (s *Scanner) unread() { _ = s.r.UnreadRune() }
+func (s *Scanner) unread() {
+  _ = s.r.UnreadRune()
+  s.pos = s.prev
+}
 
 // isWhitespace returns true if the rune is a space, tab, or newline.
 func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' }
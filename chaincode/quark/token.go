@@ -1,5 +1,11 @@
 package quark
 
+// Pos represents the line and column (both starting at 1) of a token in the source
+type Pos struct {
+  Line    int
+  Column  int
+}
+
 // Token represents a lexical token
 type Token int
 const (
@@ -10,12 +16,25 @@ const (
 
   // Literals
   IDENT
+  STRING   // "quoted string"
 
   // Misc characters
   LBRACKET // [
   RBRACKET // ]
   EQUALS   // =
   COMMA    // ,
+  COLON    // :
+  LPAREN   // (
+  RPAREN   // )
+  DOT      // .
+
+  // Comparison operators
+  EQ       // ==
+  NEQ      // !=
+  LT       // <
+  LTE      // <=
+  GT       // >
+  GTE      // >=
 
   // Keywords
   SPEC
@@ -23,4 +42,7 @@ const (
   CREATE
   DETACH
   DISCHARGE
+
+  // 'where', 'after' and 'by' are only keywords in clause position (see GetGuard and GetDeadline),
+  // and scan as identifiers so that they remain valid event and argument names
 )
@@ -0,0 +1,104 @@
+package quark
+
+import (
+  "fmt"
+  "regexp"
+  "sort"
+  "strconv"
+)
+
+// Matches decimal values such as 10, 10.99 or -0.5
+var decimalRegexp = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Checks a value against the type of this argument
+func (arg Arg) Check(value string) error {
+  switch arg.Type {
+    case TypeInt:
+      if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+        return fmt.Errorf("%q must be an int, found %q", arg.Name, value)
+      }
+    case TypeDecimal:
+      if !decimalRegexp.MatchString(value) {
+        return fmt.Errorf("%q must be a decimal, found %q", arg.Name, value)
+      }
+    case TypeBool:
+      if _, err := strconv.ParseBool(value); err != nil {
+        return fmt.Errorf("%q must be a bool, found %q", arg.Name, value)
+      }
+    case TypeEnum:
+      for _, option := range arg.Options {
+        if value == option {
+          return nil
+        }
+      }
+      return fmt.Errorf("%q must be one of %v, found %q", arg.Name, arg.Options, value)
+  }
+  return nil
+}
+
+// Builtin fields clients supply on every event. The chaincode sets the others itself: the date
+// (transaction timestamp) and, outside the create event, the debtor and creditor
+var suppliedFields = []string{"comID", "businessDate"}
+
+// Checks an event payload against this event: every declared argument must be present with a
+// value of its type, and the only other fields allowed are docType, spec, the builtin fields
+// clients supply and extra. Builtin fields set by the chaincode are reported as such
+func (event *Event) Validate(data map[string]string, extra ...string) error {
+  declared := map[string]bool{"docType": true, "spec": true}
+  for _, name := range append(suppliedFields, extra...) {
+    declared[name] = true
+  }
+  for _, arg := range event.Args {
+    if arg.Name == "deadline" {
+      continue
+    }
+    declared[arg.Name] = true
+    value, ok := data[arg.Name]
+    if !ok || value == "" {
+      return fmt.Errorf("invalid %s event: missing argument %q", event.Name, arg.Name)
+    }
+    if err := arg.Check(value); err != nil {
+      return fmt.Errorf("invalid %s event: %v", event.Name, err)
+    }
+  }
+
+  // Report undeclared fields in a stable order, those set by the chaincode first
+  reserved, undeclared := []string{}, []string{}
+  for name := range data {
+    if declared[name] {
+      continue
+    } else if isBuiltinField(name) {
+      reserved = append(reserved, name)
+    } else {
+      undeclared = append(undeclared, name)
+    }
+  }
+  sort.Strings(reserved)
+  sort.Strings(undeclared)
+  if len(reserved) > 0 {
+    return fmt.Errorf("invalid %s event: %q set by the chaincode, can't be supplied", event.Name, reserved)
+  } else if len(undeclared) > 0 {
+    return fmt.Errorf("invalid %s event: undeclared argument(s) %q", event.Name, undeclared)
+  }
+  return nil
+}
+
+// Whether a field is available on every event without being declared (see builtinFields)
+func isBuiltinField(name string) bool {
+  for _, field := range builtinFields {
+    if field == name {
+      return true
+    }
+  }
+  return false
+}
+
+// Obtains the create, detach or discharge event of this spec with the given name
+func (spec *Spec) FindEvent(name string) *Event {
+  for _, event := range []*Event{spec.CreateEvent, spec.DetachEvent, spec.DischargeEvent} {
+    if event.Name == name {
+      return event
+    }
+  }
+  return nil
+}
@@ -0,0 +1,58 @@
+package quark
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestArgCheck(t *testing.T) {
+  tests := []struct {
+    arg    Arg
+    value  string
+    valid  bool
+  }{
+    {Arg{Name: "item", Type: TypeString}, "", true},
+    {Arg{Name: "qty", Type: TypeInt}, "-3", true},
+    {Arg{Name: "qty", Type: TypeInt}, "1.5", false},
+    {Arg{Name: "price", Type: TypeDecimal}, "10.99", true},
+    {Arg{Name: "price", Type: TypeDecimal}, "10.", false},
+    {Arg{Name: "price", Type: TypeDecimal}, "1e3", false},
+    {Arg{Name: "gift", Type: TypeBool}, "true", true},
+    {Arg{Name: "gift", Type: TypeBool}, "yes", false},
+    {Arg{Name: "quality", Type: TypeEnum, Options: []string{"Good", "Damaged"}}, "Damaged", true},
+    {Arg{Name: "quality", Type: TypeEnum, Options: []string{"Good", "Damaged"}}, "good", false},
+  }
+
+  for _, test := range tests {
+    if err := test.arg.Check(test.value); (err == nil) != test.valid {
+      t.Errorf("%s %s = %q: %v", test.arg.Type, test.arg.Name, test.value, err)
+    }
+  }
+}
+
+func TestEventValidate(t *testing.T) {
+  event := &Event{Name: "Offer", Args: []Arg{{Name: "item", Type: TypeString}, {Name: "price", Type: TypeDecimal}}}
+  tests := []struct {
+    data   map[string]string
+    extra  []string
+    err    string  // a part of the error expected, "" if the payload is valid
+  }{
+    {map[string]string{"docType": "Offer", "item": "chair", "price": "30"}, nil, ""},
+    {map[string]string{"docType": "Offer", "spec": "S", "item": "chair", "price": "30", "comID": "c1", "businessDate": "Fri Jan  5 10:00:00 2018"}, nil, ""},
+    {map[string]string{"item": "chair", "price": "30", "debtor": "Shop", "creditorID": "User2"}, []string{"debtor", "creditorID"}, ""},
+    {map[string]string{"item": "chair", "price": "thirty"}, nil, "must be a decimal"},
+    {map[string]string{"item": "chair"}, nil, "missing argument \"price\""},
+    {map[string]string{"item": "", "price": "30"}, nil, "missing argument \"item\""},
+    {map[string]string{"item": "chair", "price": "30", "colour": "red", "size": "L"}, nil, "undeclared argument(s) [\"colour\" \"size\"]"},
+    {map[string]string{"item": "chair", "price": "30", "date": "Fri Jan  5 10:00:00 2018", "colour": "red"}, nil, "[\"date\"] set by the chaincode"},
+  }
+
+  for _, test := range tests {
+    err := event.Validate(test.data, test.extra...)
+    if test.err == "" && err != nil {
+      t.Errorf("%v: %v", test.data, err)
+    } else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+      t.Errorf("%v: got error %v, expected %q", test.data, err, test.err)
+    }
+  }
+}
@@ -1,21 +1,148 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"os"
   "io/ioutil"
   "encoding/json"
   "reflect"
   "log"
+  "strings"
+  "time"
 
 	"github.com/scc300/scc300-network/blockchain"
+  q "github.com/scc300/scc300-network/chaincode/quark"
   "github.com/scc300/scc300-network/web"
   "github.com/scc300/scc300-network/web/controllers"
 )
 
 // Blockchain initialization and start customer and merchant web applications
 func main() {
-  
+  local := flag.Bool("local", false, "run the chaincode in-memory instead of on the Fabric network")
+  watchInterval := flag.Duration("watch-interval", time.Minute, "how often to record commitments past their deadline as expired or violated (0 to disable)")
+  hashPassword := flag.Bool("hash-password", false, "read a password from standard input, print its hash for web/users.json and exit")
+  flag.Parse()
+
+  if *hashPassword {
+    password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+    password = strings.TrimRight(password, "\r\n")
+    if password == "" {
+      log.Fatalf("No password given on standard input\n")
+    }
+    fmt.Println(controllers.HashPassword(password))
+    return
+  }
+
+  var ledger blockchain.Ledger
+  if *local {
+    localLedger, err := blockchain.NewLocalLedger("org1.hf.scc300.io", "org1.hf.scc300.io", "User1")
+    if err != nil {
+      fmt.Printf("Unable to create the local ledger: %v\n", err)
+      return
+    }
+    ledger = localLedger
+  } else {
+    fSetup := setupFabric()
+    if fSetup == nil {
+      return
+    }
+    // Close SDK
+    defer fSetup.CloseSDK()
+    ledger = fSetup
+  }
+
+  // Commitment initialisation - Get spec source from file and initialise
+  specSource := getSpecSource("./specs/SellItem.quark")
+  _, err := ledger.InvokeInitSpec(specSource)
+  if err != nil {
+    log.Fatalf("Unable to initialise SellItem commitment on the chaincode: %v\n", err)
+  }
+
+  // SellBook shares its Offer and Pay events with SellItem, events are kept apart by spec
+  _, err = ledger.InvokeInitSpec(getSpecSource("./specs/SellBook.quark"))
+  if err != nil {
+    log.Fatalf("Unable to initialise SellBook commitment on the chaincode: %v\n", err)
+  }
+
+  // Commitment Data Initialisation - Read JSON file and add initial data to blockchain (because we assume data already exists)
+  jsonStrs := getJSONObjectStrsFromFile("./specs/test_data.json")
+  err = seedCommitmentData(ledger, specSource, jsonStrs)
+  if err != nil {
+    log.Fatalf("Unable to initialise commitment data on the chaincode: %v\n", err)
+  }
+
+  // User accounts of the applications, each mapped to a Fabric user of the organisation
+  users, err := controllers.LoadUsers("./web/users.json")
+  if err != nil {
+    log.Fatalf("Unable to load the application users: %v\n", err)
+  }
+
+  // Record commitments as expired or violated on the ledger as their deadlines pass
+  if *watchInterval > 0 {
+    stop := make(chan struct{})
+    defer close(stop)
+    watcher := &blockchain.DeadlineWatcher{Ledger: ledger, Interval: *watchInterval}
+    go watcher.Run(stop)
+  }
+
+  // Create 2 servers - 1 merchant, 1 customer
+  web.StartServers(&controllers.Application{
+    Ledger: ledger,
+    Users: users,
+  })
+}
+
+// Adds the initial commitment data, submitting each event as the party the chaincode accepts it from:
+// create and discharge events as the debtor, detach events as the creditor (see the debtorID and
+// creditorID of the create events)
+func seedCommitmentData(ledger blockchain.Ledger, specSource string, jsonStrs []string) error {
+  spec, diags := q.Parse(specSource)
+  if len(diags) > 0 {
+    return diags
+  }
+
+  parties := make(map[string]map[string]string)  // user of each role, by commitment ID
+  for _, jsonStr := range jsonStrs {
+    var event map[string]string
+    json.Unmarshal([]byte(jsonStr), &event)
+    if event["docType"] == spec.CreateEvent.Name {
+      parties[event["comID"]] = map[string]string{
+        "debtor": identityUser(event["debtorID"]),
+        "creditor": identityUser(event["creditorID"]),
+      }
+    }
+    role := "debtor"
+    if event["docType"] == spec.DetachEvent.Name {
+      role = "creditor"
+    }
+
+    submitter := ledger
+    if userName := parties[event["comID"]][role]; userName != "" {
+      user, err := ledger.ForUser(userName)
+      if err != nil {
+        return err
+      }
+      submitter = user
+    }
+    if _, err := submitter.InvokeInitCommitmentData([]string{jsonStr}); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// The organisation user of a client identity, e.g. User2 for User2@org1.hf.scc300.io
+func identityUser(identity string) string {
+  if i := strings.LastIndex(identity, ":"); i >= 0 {
+    identity = identity[i+1:]
+  }
+  return strings.Split(identity, "@")[0]
+}
+
+// Initializes the Fabric SDK and installs and instantiates the chaincode on the network
+func setupFabric() *blockchain.FabricSetup {
 	// Definition of the Fabric SDK properties
 	fSetup := blockchain.FabricSetup{
 		// Network parameters
@@ -41,36 +168,18 @@ func main() {
 	err := fSetup.Initialize()
 	if err != nil {
 		fmt.Printf("Unable to initialize the Fabric SDK: %v\n", err)
-		return
+		return nil
 	}
-	// Close SDK
-	defer fSetup.CloseSDK()
 
 	// Install and instantiate the chaincode
 	err = fSetup.InstallAndInstantiateCC()
 	if err != nil {
 		fmt.Printf("Unable to install and instantiate the chaincode: %v\n", err)
-		return
+		fSetup.CloseSDK()
+		return nil
 	}
 
-  // Commitment initialisation - Get spec source from file and initialise
-  specSource := getSpecSource("./specs/SellItem.quark")
-  _, err = fSetup.InvokeInitSpec(specSource)
-  if err != nil {
-    log.Fatalf("Unable to initialise SellItem commitment on the chaincode: %v\n", err)
-  }
-
-  // Commitment Data Initialisation - Read JSON file and add initial data to blockchain (because we assume data already exists)
-  jsonStrs := getJSONObjectStrsFromFile("./specs/test_data.json")
-	_, err = fSetup.InvokeInitCommitmentData(jsonStrs)
-  if err != nil {
-    log.Fatalf("Unable to initialise commitment data on the chaincode: %v\n", err)
-  }
-
-  // Create 2 servers - 1 merchant, 1 customer
-  web.StartServers(&controllers.Application{
-    Fabric: &fSetup,
-  })
+	return &fSetup
 }
 
 // Function to obtain the specification source code as a string (input is a filepath to the .quark file)
@@ -0,0 +1,574 @@
+package controllers
+
+import (
+  "encoding/json"
+  "fmt"
+  "mime"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/satori/go.uuid"
+  "github.com/scc300/scc300-network/blockchain"
+  q "github.com/scc300/scc300-network/chaincode/quark"
+)
+
+// Prefix of the versioned JSON API, served by both the merchant and customer applications
+const APIPrefix = "/api/v1/"
+
+// Default and largest number of commitments listed per page by the API
+const (
+  APIPageSize = 100
+  APIMaxPageSize = 1000
+)
+
+// A spec as returned by the API, with its events and their arguments
+type apiSpec struct {
+  Name      string      `json:"name"`
+  Version   int         `json:"version"`
+  Debtor    string      `json:"debtor"`
+  Creditor  string      `json:"creditor"`
+  Source    string      `json:"source"`
+  Events    []apiEvent  `json:"events"`
+}
+
+// An event of a spec (kind is create, detach or discharge)
+type apiEvent struct {
+  Kind  string    `json:"kind"`
+  Name  string    `json:"name"`
+  Args  []apiArg  `json:"args"`
+}
+
+// An argument of an event
+type apiArg struct {
+  Name     string    `json:"name"`
+  Type     string    `json:"type"`
+  Options  []string  `json:"options,omitempty"`
+}
+
+// A commitment as returned by the API
+type apiCommitment struct {
+  ComID     string      `json:"comID"`
+  Spec      string      `json:"spec"`
+  SpecVersion int       `json:"specVersion"`  // version of the spec the commitment was created under
+  State     string      `json:"state"`
+  Transitions  map[string]time.Time  `json:"transitions"`  // when each state was entered
+  Deadline  *time.Time  `json:"deadline"`       // when the next event is due (null once the commitment has ended)
+  TimeRemaining  int64  `json:"timeRemaining"`  // seconds left until the deadline
+  Debtor    string      `json:"debtor"`
+  Creditor  string      `json:"creditor"`
+  States    []apiState  `json:"states"`
+  Events    map[string][]map[string]interface{}  `json:"events"`
+}
+
+// The event data of a commitment state (null if the event hasn't occurred)
+type apiState struct {
+  Name  string                  `json:"name"`
+  Data  map[string]interface{}  `json:"data"`
+}
+
+// Body of POST /api/v1/specs and POST /api/v1/specs/{name}/versions
+type apiNewSpec struct {
+  Source  string  `json:"source"`
+}
+
+// Body of POST /api/v1/commitments
+type apiNewCommitment struct {
+  Spec        string                  `json:"spec"`
+  Debtor      string                  `json:"debtor"`
+  Creditor    string                  `json:"creditor"`
+  DebtorID    string                  `json:"debtorID"`    // client identity bound to the debtor role, which must be the caller
+  CreditorID  string                  `json:"creditorID"`  // client identity bound to the creditor role
+  Data        map[string]interface{}  `json:"data"`
+}
+
+// Body of POST /api/v1/commitments/{id}/events
+type apiNewEvent struct {
+  Event  string                  `json:"event"`
+  Data   map[string]interface{}  `json:"data"`
+}
+
+// Body of every error response
+type apiError struct {
+  Error        string          `json:"error"`
+  Diagnostics  []apiDiagnostic  `json:"diagnostics,omitempty"`
+  Rejected     []string         `json:"rejected,omitempty"`  // why each rejected event was refused
+}
+
+// A spec compilation error
+type apiDiagnostic struct {
+  Line     int     `json:"line"`
+  Column   int     `json:"column"`
+  Message  string  `json:"message"`
+}
+
+// Handler for the JSON API. Routes:
+//   GET  /api/v1/specs/{name}
+//   POST /api/v1/specs
+//   GET  /api/v1/specs/{name}/versions
+//   POST /api/v1/specs/{name}/versions
+//   GET  /api/v1/specs/{name}/commitments?state=&asOf=&pageSize=&bookmark=&debtor=&creditor=&createdAfter=&createdBefore=&arg=
+//   GET  /api/v1/specs/{name}/stats?groupBy=&asOf=
+//   POST /api/v1/commitments
+//   GET  /api/v1/commitments/{id}?spec=
+//   POST /api/v1/commitments/{id}/events
+func (app *Application) APIHandler(w http.ResponseWriter, r *http.Request) {
+  path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+  ledger, err := app.userLedger(r)
+  if err != nil {
+    writeError(w, http.StatusBadGateway, err.Error())
+    return
+  }
+
+  // Forms of other sites can't send JSON, so requests signed in with the session cookie can't be forged
+  if r.Method == "POST" && !isJSON(r) {
+    writeError(w, http.StatusUnsupportedMediaType, "expected a JSON body (Content-Type: application/json)")
+    return
+  }
+
+  switch {
+    case len(path) == 1 && path[0] == "specs":
+      if allowMethod(w, r, "POST") {
+        apiCreateSpec(ledger, w, r)
+      }
+    case len(path) == 2 && path[0] == "specs":
+      if allowMethod(w, r, "GET") {
+        apiGetSpec(ledger, w, r, path[1])
+      }
+    case len(path) == 3 && path[0] == "specs" && path[2] == "versions":
+      if r.Method == "POST" {
+        apiPublishSpecVersion(ledger, w, r, path[1])
+      } else if allowMethod(w, r, "GET") {
+        apiGetSpecVersions(ledger, w, r, path[1])
+      }
+    case len(path) == 3 && path[0] == "specs" && path[2] == "commitments":
+      if allowMethod(w, r, "GET") {
+        apiGetCommitments(ledger, w, r, path[1])
+      }
+    case len(path) == 3 && path[0] == "specs" && path[2] == "stats":
+      if allowMethod(w, r, "GET") {
+        apiGetStats(ledger, w, r, path[1])
+      }
+    case len(path) == 1 && path[0] == "commitments":
+      if allowMethod(w, r, "POST") {
+        apiCreateCommitment(ledger, w, r)
+      }
+    case len(path) == 2 && path[0] == "commitments":
+      if allowMethod(w, r, "GET") {
+        apiGetCommitment(ledger, w, r, path[1])
+      }
+    case len(path) == 3 && path[0] == "commitments" && path[2] == "events":
+      if allowMethod(w, r, "POST") {
+        apiAddEvent(ledger, w, r, path[1])
+      }
+    default:
+      writeError(w, http.StatusNotFound, "no such endpoint: " + r.URL.Path)
+  }
+}
+
+// GET /api/v1/specs/{name} - a spec and its events
+func apiGetSpec(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, name string) {
+  spec, parsed, status, err := getParsedSpec(ledger, name)
+  if err != nil {
+    writeError(w, status, err.Error())
+    return
+  }
+  writeJSON(w, http.StatusOK, newAPISpec(spec, parsed))
+}
+
+// POST /api/v1/specs - compiles and registers a spec, reporting any compilation errors (merchants only)
+func apiCreateSpec(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request) {
+  if requestUser(r).Role != RoleMerchant {
+    writeError(w, http.StatusForbidden, "only merchants can register specs")
+    return
+  }
+  var body apiNewSpec
+  if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Source == "" {
+    writeError(w, http.StatusBadRequest, "expected a JSON body with the spec source, e.g. {\"source\": \"spec ...\"}")
+    return
+  }
+
+  // Compile spec to check syntax
+  parsed, diags := q.Parse(body.Source)
+  if len(diags) > 0 {
+    res := apiError{Error: fmt.Sprintf("%d error(s) found", len(diags))}
+    for _, diag := range diags {
+      res.Diagnostics = append(res.Diagnostics, apiDiagnostic{Line: diag.Pos.Line, Column: diag.Pos.Column, Message: diag.Message})
+    }
+    writeJSON(w, http.StatusUnprocessableEntity, res)
+    return
+  }
+
+  // Upload new spec to blockchain
+  if _, err := ledger.InvokeInitSpec(body.Source); err != nil {
+    writeError(w, http.StatusUnprocessableEntity, err.Error())
+    return
+  }
+  spec := &blockchain.Spec{ObjectType: "spec", Name: parsed.Constraint.Name, Source: body.Source, Version: 1}
+  writeJSON(w, http.StatusCreated, newAPISpec(spec, parsed))
+}
+
+// GET /api/v1/specs/{name}/versions - every version of a spec, oldest first
+func apiGetSpecVersions(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, name string) {
+  if _, _, status, err := getParsedSpec(ledger, name); err != nil {
+    writeError(w, status, err.Error())
+    return
+  }
+  versions, err := ledger.GetSpecVersions(name)
+  if err != nil {
+    writeError(w, http.StatusBadGateway, err.Error())
+    return
+  }
+
+  res := []apiSpec{}
+  for i := range versions {
+    parsed, diags := q.Parse(versions[i].Source)
+    if len(diags) > 0 {
+      writeError(w, http.StatusInternalServerError, diags.Error())
+      return
+    }
+    res = append(res, newAPISpec(&versions[i], parsed))
+  }
+  writeJSON(w, http.StatusOK, res)
+}
+
+// POST /api/v1/specs/{name}/versions - publishes a new version of a spec (merchants only). Existing
+// commitments stay on the version they were created under
+func apiPublishSpecVersion(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, name string) {
+  if requestUser(r).Role != RoleMerchant {
+    writeError(w, http.StatusForbidden, "only merchants can publish specs")
+    return
+  }
+  previous, _, status, err := getParsedSpec(ledger, name)
+  if err != nil {
+    writeError(w, status, err.Error())
+    return
+  }
+  var body apiNewSpec
+  if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Source == "" {
+    writeError(w, http.StatusBadRequest, "expected a JSON body with the spec source, e.g. {\"source\": \"spec ...\"}")
+    return
+  }
+
+  // Compile spec to check syntax
+  parsed, diags := q.Parse(body.Source)
+  if len(diags) > 0 {
+    res := apiError{Error: fmt.Sprintf("%d error(s) found", len(diags))}
+    for _, diag := range diags {
+      res.Diagnostics = append(res.Diagnostics, apiDiagnostic{Line: diag.Pos.Line, Column: diag.Pos.Column, Message: diag.Message})
+    }
+    writeJSON(w, http.StatusUnprocessableEntity, res)
+    return
+  }
+  if parsed.Constraint.Name != name {
+    writeError(w, http.StatusBadRequest, fmt.Sprintf("the source is spec %s, not %s", parsed.Constraint.Name, name))
+    return
+  }
+
+  if _, err := ledger.InvokeUpgradeSpec(body.Source); err != nil {
+    writeError(w, http.StatusUnprocessableEntity, err.Error())
+    return
+  }
+  version := previous.Version
+  if version < 1 {
+    version = 1
+  }
+  spec := &blockchain.Spec{ObjectType: "spec", Name: name, Source: body.Source, Version: version + 1}
+  writeJSON(w, http.StatusCreated, newAPISpec(spec, parsed))
+}
+
+// GET /api/v1/specs/{name}/commitments?state=&asOf=&pageSize=&bookmark= - a page of the commitments of a spec in
+// a state (created by default, all for any state), evaluated as of a date (now by default). The next page, if any,
+// is linked to by the Link header (rel="next"). The commitments can be filtered by debtor, creditor, creation date
+// (createdAfter inclusive, createdBefore exclusive) and event data with one or more arg predicates, e.g.
+// ?arg=item=Chair&arg=price>20. Only the commitments the caller is the debtor or creditor of are listed
+func apiGetCommitments(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, name string) {
+  if _, _, status, err := getParsedSpec(ledger, name); err != nil {
+    writeError(w, status, err.Error())
+    return
+  }
+  params := r.URL.Query()
+  state := strings.ToLower(params.Get("state"))
+  if state == "" {
+    state = "created"
+  }
+  filter := blockchain.CommitmentFilter{Debtor: params.Get("debtor"), Creditor: params.Get("creditor"), Args: params["arg"]}
+  var asOf time.Time
+  dates := []struct{ param string; date *time.Time }{{"asOf", &asOf}, {"createdAfter", &filter.CreatedAfter}, {"createdBefore", &filter.CreatedBefore}}
+  for _, d := range dates {
+    param, date := d.param, d.date
+    if value := params.Get(param); value != "" {
+      var err error
+      if *date, err = time.Parse(time.RFC3339, value); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid " + param + " date " + value + ", expected RFC 3339 (e.g. 2019-03-01T12:00:00Z)")
+        return
+      }
+    }
+  }
+  pageSize := APIPageSize
+  if param := r.URL.Query().Get("pageSize"); param != "" {
+    var err error
+    if pageSize, err = strconv.Atoi(param); err != nil || pageSize < 1 || pageSize > APIMaxPageSize {
+      writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid pageSize %s, expected 1 to %d", param, APIMaxPageSize))
+      return
+    }
+  }
+  var page *blockchain.CommitmentPage
+  var err error
+  if state == "all" {
+    page, err = ledger.GetFilteredCommitmentsPage(name, "", filter, asOf, pageSize, params.Get("bookmark"))
+  } else if !filter.IsZero() {
+    page, err = ledger.GetFilteredCommitmentsPage(name, state, filter, asOf, pageSize, params.Get("bookmark"))
+  } else {
+    page, err = ledger.GetCommitmentsPage(name, state, asOf, pageSize, params.Get("bookmark"))
+  }
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  if page.Bookmark != "" {
+    next := r.URL.Query()
+    next.Set("bookmark", page.Bookmark)
+    w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+  }
+
+  res := []apiCommitment{}
+  for _, com := range page.Commitments {
+    res = append(res, newAPICommitment(com))
+  }
+  writeJSON(w, http.StatusOK, res)
+}
+
+// GET /api/v1/specs/{name}/stats?groupBy=&asOf= - the counts, percentages and average times of the commitments of a
+// spec by current state, in total and per debtor and creditor with their reputation (creditors on detaching rather
+// than letting them expire, everyone else on discharging rather than violating them), and per value of the groupBy
+// event argument if given (e.g. Delivery.courier), evaluated as of a date (now by default). Merchants only
+func apiGetStats(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, name string) {
+  if requestUser(r).Role != RoleMerchant {
+    writeError(w, http.StatusForbidden, "only merchants can view commitment stats")
+    return
+  }
+  if _, _, status, err := getParsedSpec(ledger, name); err != nil {
+    writeError(w, status, err.Error())
+    return
+  }
+  var asOf time.Time
+  if param := r.URL.Query().Get("asOf"); param != "" {
+    var err error
+    if asOf, err = time.Parse(time.RFC3339, param); err != nil {
+      writeError(w, http.StatusBadRequest, "invalid asOf date " + param + ", expected RFC 3339 (e.g. 2019-03-01T12:00:00Z)")
+      return
+    }
+  }
+  stats, err := ledger.GetCommitmentStats(name, r.URL.Query().Get("groupBy"), asOf)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  writeJSON(w, http.StatusOK, stats)
+}
+
+// POST /api/v1/commitments - creates a commitment of a spec with its create event data
+func apiCreateCommitment(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request) {
+  var body apiNewCommitment
+  if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Spec == "" {
+    writeError(w, http.StatusBadRequest, "expected a JSON body with the spec name, debtor, creditor, creditor identity and create event data")
+    return
+  }
+  _, parsed, status, err := getParsedSpec(ledger, body.Spec)
+  if err != nil {
+    writeError(w, status, err.Error())
+    return
+  }
+
+  comID := uuid.NewV4().String()
+  event := eventData(body.Data)
+  event["docType"] = parsed.CreateEvent.Name
+  event["spec"] = parsed.Constraint.Name
+  event["comID"] = comID
+  event["debtor"] = body.Debtor
+  event["creditor"] = body.Creditor
+  event["creditorID"] = body.CreditorID
+  if body.DebtorID != "" {
+    event["debtorID"] = body.DebtorID
+  }
+
+  txID, err := invokeEvent(ledger, event)
+  if err != nil {
+    writeJSON(w, rejectionStatus(err), rejectionReport(err))
+    return
+  }
+  writeJSON(w, http.StatusCreated, map[string]string{"comID": comID, "txID": txID})
+}
+
+// GET /api/v1/commitments/{id}?spec= - a single commitment with its full lifecycle (of the given spec, if any).
+// Only the debtor and creditor of a commitment can read it
+func apiGetCommitment(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, comID string) {
+  com, err := ledger.GetCommitment(r.URL.Query().Get("spec"), comID)
+  if err != nil {
+    switch blockchain.ErrorStatus(err) {
+      case blockchain.StatusNotFound:
+        writeError(w, http.StatusNotFound, "commitment " + comID + " does not exist")
+      case blockchain.StatusForbidden:
+        writeError(w, http.StatusForbidden, "only the debtor and creditor of commitment " + comID + " can read it")
+      default:
+        writeError(w, http.StatusBadGateway, err.Error())
+    }
+    return
+  }
+  writeJSON(w, http.StatusOK, newAPICommitment(*com))
+}
+
+// POST /api/v1/commitments/{id}/events - adds detach or discharge event data to a commitment
+func apiAddEvent(ledger blockchain.Ledger, w http.ResponseWriter, r *http.Request, comID string) {
+  var body apiNewEvent
+  if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Event == "" {
+    writeError(w, http.StatusBadRequest, "expected a JSON body with the event name and data, e.g. {\"event\": \"Pay\", \"data\": {...}}")
+    return
+  }
+
+  event := eventData(body.Data)
+  event["docType"] = body.Event
+  event["comID"] = comID
+
+  txID, err := invokeEvent(ledger, event)
+  if err != nil {
+    writeJSON(w, rejectionStatus(err), rejectionReport(err))
+    return
+  }
+  writeJSON(w, http.StatusCreated, map[string]string{"comID": comID, "txID": txID})
+}
+
+// Obtains a spec from the ledger and parses it, with the status code to report on failure
+func getParsedSpec(ledger blockchain.Ledger, name string) (*blockchain.Spec, *q.Spec, int, error) {
+  spec, err := ledger.GetSpec(name)
+  if err != nil {
+    if blockchain.ErrorStatus(err) == blockchain.StatusNotFound {
+      return nil, nil, http.StatusNotFound, fmt.Errorf("spec %s does not exist", name)
+    }
+    return nil, nil, http.StatusBadGateway, err
+  }
+  parsed, diags := q.Parse(spec.Source)
+  if len(diags) > 0 {
+    return nil, nil, http.StatusInternalServerError, diags
+  }
+  return spec, parsed, http.StatusOK, nil
+}
+
+// Submits a single event to the ledger
+func invokeEvent(ledger blockchain.Ledger, event map[string]string) (string, error) {
+  eventJSON, _ := json.Marshal(event)
+  return ledger.InvokeInitCommitmentData([]string{string(eventJSON)})
+}
+
+// Status code of a transaction rejected by the chaincode: 403 if the caller isn't a party entitled to it,
+// 404 if its spec or commitment doesn't exist
+func rejectionStatus(err error) int {
+  switch blockchain.ErrorStatus(err) {
+    case blockchain.StatusForbidden:
+      return http.StatusForbidden
+    case blockchain.StatusNotFound:
+      return http.StatusNotFound
+  }
+  return http.StatusUnprocessableEntity
+}
+
+// Error body of a transaction rejected by the chaincode, listing the reason for each rejected event
+func rejectionReport(err error) apiError {
+  res := apiError{Error: err.Error()}
+  for _, line := range strings.Split(err.Error(), "\n") {
+    if strings.HasPrefix(line, "event ") {
+      res.Rejected = append(res.Rejected, line)
+    }
+  }
+  return res
+}
+
+// Converts JSON event data to the string values stored on the ledger
+func eventData(data map[string]interface{}) map[string]string {
+  event := map[string]string{}
+  for key, value := range data {
+    if str, ok := value.(string); ok {
+      event[key] = str
+    } else {
+      valueJSON, _ := json.Marshal(value)
+      event[key] = string(valueJSON)
+    }
+  }
+  return event
+}
+
+func newAPISpec(spec *blockchain.Spec, parsed *q.Spec) apiSpec {
+  res := apiSpec{
+    Name: spec.Name,
+    Version: spec.Version,
+    Debtor: parsed.Constraint.Debtor,
+    Creditor: parsed.Constraint.Creditor,
+    Source: spec.Source,
+  }
+  kinds := []string{"create", "detach", "discharge"}
+  for i, event := range []*q.Event{parsed.CreateEvent, parsed.DetachEvent, parsed.DischargeEvent} {
+    apiEv := apiEvent{Kind: kinds[i], Name: event.Name, Args: []apiArg{}}
+    for _, arg := range event.Args {
+      if arg.Name != "deadline" {
+        apiEv.Args = append(apiEv.Args, apiArg{Name: arg.Name, Type: arg.Type, Options: arg.Options})
+      }
+    }
+    res.Events = append(res.Events, apiEv)
+  }
+  return res
+}
+
+func newAPICommitment(com blockchain.Commitment) apiCommitment {
+  res := apiCommitment{
+    ComID: com.ComID,
+    Spec: com.Spec,
+    SpecVersion: com.SpecVersion,
+    State: com.State,
+    Transitions: map[string]time.Time{},
+    TimeRemaining: com.TimeRemaining,
+    Debtor: com.Debtor,
+    Creditor: com.Creditor,
+    States: []apiState{},
+    Events: com.Events,
+  }
+  for state, date := range com.Transitions {
+    if parsed, err := time.Parse(blockchain.TimeFormat, date); err == nil {
+      res.Transitions[state] = parsed
+    }
+  }
+  if deadline, err := time.Parse(blockchain.TimeFormat, com.Deadline); err == nil {
+    res.Deadline = &deadline
+  }
+  for _, state := range com.States {
+    res.States = append(res.States, apiState{Name: state.Name, Data: state.Data})
+  }
+  return res
+}
+
+// Rejects requests with any other method, returning whether the method is allowed
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+  if r.Method != method {
+    w.Header().Set("Allow", method)
+    writeError(w, http.StatusMethodNotAllowed, "method " + r.Method + " not allowed, expected " + method)
+    return false
+  }
+  return true
+}
+
+// Whether a request has a JSON body
+func isJSON(r *http.Request) bool {
+  mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  return err == nil && mediaType == "application/json"
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+  writeJSON(w, status, apiError{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(body)
+}
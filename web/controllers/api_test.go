@@ -0,0 +1,148 @@
+package controllers
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "sort"
+  "strings"
+  "testing"
+
+  "github.com/scc300/scc300-network/blockchain"
+)
+
+// An application on a local ledger where the merchant (User1) has made one commitment to each of two customers:
+// sale to alice (User2) and gift to bob (User3)
+func newTestApp(t *testing.T) *Application {
+  ledger, err := blockchain.NewLocalLedger("Org1MSP", "org1.hf.scc300.io", "User1")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := ledger.InvokeInitSpec("spec SellItem dID to cID\n  create Offer [item]\n  detach Pay [amount] deadline=1w\n  discharge Delivery [courier] deadline=1w"); err != nil {
+    t.Fatal(err)
+  }
+  for _, event := range []string{
+    `{"docType":"Offer","spec":"SellItem","comID":"sale","item":"chair","debtor":"Shop","creditor":"Alice","creditorID":"User2@org1.hf.scc300.io"}`,
+    `{"docType":"Offer","spec":"SellItem","comID":"gift","item":"lamp","debtor":"Shop","creditor":"Bob","creditorID":"User3@org1.hf.scc300.io"}`,
+  } {
+    if _, err := ledger.InvokeInitCommitmentData([]string{event}); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  password := HashPassword("secret")
+  return &Application{Ledger: ledger, Users: map[string]*User{
+    "merchant": {Username: "merchant", Password: password, Role: RoleMerchant, Identity: "User1"},
+    "alice": {Username: "alice", Password: password, Role: RoleCustomer, Identity: "User2"},
+    "bob": {Username: "bob", Password: password, Role: RoleCustomer, Identity: "User3"},
+  }}
+}
+
+// Serves a GET request for a user, signed in with basic authentication
+func serveAs(app *Application, handler http.HandlerFunc, username string, target string) *httptest.ResponseRecorder {
+  r := httptest.NewRequest("GET", target, nil)
+  r.SetBasicAuth(username, "secret")
+  w := httptest.NewRecorder()
+  app.Authenticate(handler)(w, r)
+  return w
+}
+
+// The IDs of a list of commitments, sorted
+func comIDs(coms []blockchain.Commitment) string {
+  ids := []string{}
+  for _, com := range coms {
+    ids = append(ids, com.ComID)
+  }
+  sort.Strings(ids)
+  return strings.Join(ids, ",")
+}
+
+func TestAPIListsOnlyTheCallersCommitments(t *testing.T) {
+  app := newTestApp(t)
+  tests := []struct {
+    user    string
+    comIDs  string
+  }{
+    {"merchant", "gift,sale"},
+    {"alice", "sale"},
+    {"bob", "gift"},
+  }
+
+  for _, test := range tests {
+    for _, target := range []string{APIPrefix + "specs/SellItem/commitments?state=created", APIPrefix + "specs/SellItem/commitments?state=all&debtor=Shop"} {
+      w := serveAs(app, app.APIHandler, test.user, target)
+      var res []apiCommitment
+      if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+        t.Fatalf("%s: %d %s", target, w.Code, w.Body)
+      }
+      coms := []blockchain.Commitment{}
+      for _, com := range res {
+        coms = append(coms, blockchain.Commitment{ComID: com.ComID})
+      }
+      if comIDs(coms) != test.comIDs {
+        t.Errorf("%s listed %s for %s, expected %s", target, comIDs(coms), test.user, test.comIDs)
+      }
+    }
+  }
+}
+
+func TestAPIGetCommitment(t *testing.T) {
+  app := newTestApp(t)
+  tests := []struct {
+    user    string
+    comID   string
+    status  int
+  }{
+    {"merchant", "gift", http.StatusOK},
+    {"alice", "sale", http.StatusOK},
+    {"alice", "gift", http.StatusForbidden},
+    {"bob", "sale", http.StatusForbidden},
+    {"alice", "missing", http.StatusNotFound},
+  }
+
+  for _, test := range tests {
+    w := serveAs(app, app.APIHandler, test.user, APIPrefix + "commitments/" + test.comID)
+    if w.Code != test.status {
+      t.Errorf("%s reading %s: %d %s, expected %d", test.user, test.comID, w.Code, w.Body, test.status)
+    }
+  }
+}
+
+func TestListingOnlyShowsTheUsersCommitments(t *testing.T) {
+  app := newTestApp(t)
+  var data Data
+  listing := func(w http.ResponseWriter, r *http.Request) {
+    data = Data{}
+    app.MainHandler(&data, w, r)
+  }
+
+  for user, expected := range map[string]string{"merchant": "gift,sale", "alice": "sale", "bob": "gift"} {
+    for _, state := range []string{"Created", "All"} {
+      serveAs(app, listing, user, "/?query-commitments=true&comname=SellItem&commitmentState=" + state)
+      if data.Failed {
+        t.Fatalf("%s listing %s commitments: %s", user, state, data.FailMsg)
+      }
+      if comIDs(data.Coms) != expected {
+        t.Errorf("%s listed %s %s commitments, expected %s", user, comIDs(data.Coms), state, expected)
+      }
+    }
+  }
+}
+
+func TestHistoryOnlyShownToParties(t *testing.T) {
+  app := newTestApp(t)
+  var data Data
+  history := func(w http.ResponseWriter, r *http.Request) {
+    data = Data{}
+    app.MainHandler(&data, w, r)
+  }
+
+  w := serveAs(app, history, "alice", "/?query-commitments=true&comname=SellItem&commitmentState=Created&history=sale")
+  if data.Failed || data.HistoryComID != "sale" || len(data.History) == 0 {
+    t.Errorf("alice's history of sale: %d entries, %s", len(data.History), data.FailMsg)
+  }
+  w = serveAs(app, history, "alice", "/?query-commitments=true&comname=SellItem&commitmentState=Created&history=gift")
+  if w.Code != http.StatusForbidden || !data.Failed || len(data.History) != 0 {
+    t.Errorf("alice's history of gift: %d with %d entries, expected %d", w.Code, len(data.History), http.StatusForbidden)
+  }
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/scc300/scc300-network/blockchain"
+  "golang.org/x/crypto/pbkdf2"
+)
+
+// Roles of the users of the applications
+const (
+  RoleMerchant = "merchant"
+  RoleCustomer = "customer"
+)
+
+const (
+  sessionCookie = "scc300-session"   // Name of the cookie holding the session token
+  sessionLifetime = 12 * time.Hour   // Time a user stays signed in
+  csrfField = "csrf-token"           // Name of the form field holding the anti-forgery token of the session
+  passwordKeyLen = 32                // Length of the keys password hashes store (bytes)
+  passwordIterations = 100000        // PBKDF2 iterations of the password hashes made by HashPassword
+)
+
+// A user of the applications. Each user transacts with their own Fabric identity
+type User struct {
+  Username  string  `json:"username"`
+  Password  string  `json:"password"`  // Password hash, pbkdf2-sha256$<iterations>$<salt>$<hash> (base64)
+  Role      string  `json:"role"`      // Role - merchant or customer
+  Identity  string  `json:"identity"`  // Identity - Fabric user of the organisation transactions are signed as (e.g. User1)
+}
+
+// Data rendered by the login page
+type LoginData struct {
+  Next   string
+  Error  string
+}
+
+// A signed in user
+type session struct {
+  user     *User
+  csrf     string     // csrf - anti-forgery token the forms that change state must carry (see checkCSRF)
+  expires  time.Time
+}
+
+// Sessions of the signed in users, keyed by token
+type sessionStore struct {
+  lock      sync.Mutex
+  sessions  map[string]*session
+}
+
+type userKey struct{}
+
+// LoadUsers reads the user accounts from a JSON file
+func LoadUsers(path string) (map[string]*User, error) {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  var list []*User
+  if err := json.Unmarshal(data, &list); err != nil {
+    return nil, fmt.Errorf("invalid users file %s: %v", path, err)
+  }
+  users := make(map[string]*User)
+  for _, user := range list {
+    if user.Role != RoleMerchant && user.Role != RoleCustomer {
+      return nil, fmt.Errorf("invalid role %q for user %s", user.Role, user.Username)
+    }
+    users[user.Username] = user
+  }
+  return users, nil
+}
+
+// Authenticate wraps a handler so it is only served to signed in users with one of the given roles.
+// Browsers are sent to the login page, API clients can use HTTP basic authentication instead
+func (app *Application) Authenticate(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    user := app.authenticatedUser(r)
+    isAPI := strings.HasPrefix(r.URL.Path, APIPrefix) || r.URL.Path == EventsPath
+
+    if user == nil {
+      if isAPI {
+        w.Header().Set("WWW-Authenticate", `Basic realm="Commitment Manager"`)
+        writeError(w, http.StatusUnauthorized, "authentication required")
+      } else {
+        http.Redirect(w, r, "/login?next=" + url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+      }
+      return
+    }
+    if !hasRole(user, roles) {
+      msg := fmt.Sprintf("%s users can't use this application", user.Role)
+      if isAPI {
+        writeError(w, http.StatusForbidden, msg)
+      } else {
+        http.Error(w, msg, http.StatusForbidden)
+      }
+      return
+    }
+    next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+  }
+}
+
+// Handler for the login page
+func (app *Application) LoginHandler(w http.ResponseWriter, r *http.Request) {
+  data := LoginData{Next: r.FormValue("next")}
+  if r.Method == "POST" {
+    user := app.checkPassword(r.FormValue("username"), r.FormValue("password"))
+    if user != nil {
+      token := app.sessions.create(user)
+      http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode, Expires: time.Now().Add(sessionLifetime)})
+      http.Redirect(w, r, safeRedirect(data.Next), http.StatusSeeOther)
+      return
+    }
+    data.Error = "Incorrect username or password"
+  }
+  renderTemplate(w, r, "login.html", data)
+}
+
+// Handler to sign out
+func (app *Application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+  if cookie, err := r.Cookie(sessionCookie); err == nil {
+    app.sessions.delete(cookie.Value)
+  }
+  http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode, MaxAge: -1})
+  http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// The signed in user of a request served through Authenticate
+func requestUser(r *http.Request) *User {
+  user, _ := r.Context().Value(userKey{}).(*User)
+  return user
+}
+
+// The ledger as seen by the signed in user, so transactions are signed with their identity
+func (app *Application) userLedger(r *http.Request) (blockchain.Ledger, error) {
+  user := requestUser(r)
+  if user == nil {
+    return nil, fmt.Errorf("not signed in")
+  }
+  return app.Ledger.ForUser(user.Identity)
+}
+
+// The anti-forgery token of the session of a request, to include in the forms that change state ("" without a session)
+func (app *Application) csrfToken(r *http.Request) string {
+  if cookie, err := r.Cookie(sessionCookie); err == nil {
+    if s := app.sessions.get(cookie.Value); s != nil {
+      return s.csrf
+    }
+  }
+  return ""
+}
+
+// Whether a form was submitted from a page of this application: a POST carrying the anti-forgery token of the
+// user's session. Another site can make the browser send the session cookie, but can't read the token
+func (app *Application) checkCSRF(r *http.Request) bool {
+  token := app.csrfToken(r)
+  return r.Method == "POST" && token != "" && subtle.ConstantTimeCompare([]byte(r.PostFormValue(csrfField)), []byte(token)) == 1
+}
+
+// Finds the user of a request from their session cookie or basic authentication credentials
+func (app *Application) authenticatedUser(r *http.Request) *User {
+  if cookie, err := r.Cookie(sessionCookie); err == nil {
+    if s := app.sessions.get(cookie.Value); s != nil {
+      return s.user
+    }
+  }
+  if username, password, ok := r.BasicAuth(); ok {
+    return app.checkPassword(username, password)
+  }
+  return nil
+}
+
+// Returns the user with the given credentials, or nil if they are incorrect
+func (app *Application) checkPassword(username string, password string) *User {
+  user, ok := app.Users[username]
+  if !ok || !verifyPassword(user.Password, password) {
+    return nil
+  }
+  return user
+}
+
+func hasRole(user *User, roles []string) bool {
+  for _, role := range roles {
+    if user.Role == role {
+      return true
+    }
+  }
+  return len(roles) == 0
+}
+
+// Only redirect to paths of this application after signing in
+func safeRedirect(next string) string {
+  if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+    return "/"
+  }
+  return next
+}
+
+// Starts a session for a user, returning its token
+func (store *sessionStore) create(user *User) string {
+  token := randomToken()
+
+  store.lock.Lock()
+  defer store.lock.Unlock()
+  if store.sessions == nil {
+    store.sessions = make(map[string]*session)
+  }
+  store.sessions[token] = &session{user: user, csrf: randomToken(), expires: time.Now().Add(sessionLifetime)}
+  return token
+}
+
+// An unexpired session, or nil
+func (store *sessionStore) get(token string) *session {
+  store.lock.Lock()
+  defer store.lock.Unlock()
+  s, ok := store.sessions[token]
+  if !ok {
+    return nil
+  }
+  if time.Now().After(s.expires) {
+    delete(store.sessions, token)
+    return nil
+  }
+  return s
+}
+
+func (store *sessionStore) delete(token string) {
+  store.lock.Lock()
+  defer store.lock.Unlock()
+  delete(store.sessions, token)
+}
+
+// A random 256-bit token, URL safe
+func randomToken() string {
+  buf := make([]byte, 32)
+  if _, err := rand.Read(buf); err != nil {
+    panic(err)
+  }
+  return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// HashPassword hashes a password for the users file, as pbkdf2-sha256$<iterations>$<salt>$<hash> with a random salt
+func HashPassword(password string) string {
+  salt := make([]byte, 16)
+  if _, err := rand.Read(salt); err != nil {
+    panic(err)
+  }
+  key := pbkdf2.Key([]byte(password), salt, passwordIterations, passwordKeyLen, sha256.New)
+  return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key))
+}
+
+// Checks a password against a pbkdf2-sha256$<iterations>$<salt>$<hash> password hash
+func verifyPassword(hash string, password string) bool {
+  parts := strings.Split(hash, "$")
+  if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+    return false
+  }
+  iterations, err := strconv.Atoi(parts[1])
+  if err != nil || iterations < 1 {
+    return false
+  }
+  salt, err := base64.StdEncoding.DecodeString(parts[2])
+  if err != nil {
+    return false
+  }
+  want, err := base64.StdEncoding.DecodeString(parts[3])
+  if err != nil {
+    return false
+  }
+  got := pbkdf2.Key([]byte(password), salt, iterations, passwordKeyLen, sha256.New)
+  return subtle.ConstantTimeCompare(got, want) == 1
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+  "fmt"
+  "net/http"
+  "sort"
+  "strings"
+  "time"
+
+  "github.com/scc300/scc300-network/blockchain"
+)
+
+// Path of the commitment detail pages, /commitments/{id}
+const CommitmentPath = "/commitments/"
+
+// Stores commitment detail page data
+type CommitmentData struct {
+  User        *User
+  ComID       string
+  Commitment  *blockchain.Commitment
+  Lifecycle   []LifecycleStep
+  Remaining   string  // Remaining - time left until the next deadline, e.g. "2d 4h 30m"
+  Failed      bool
+  FailMsg     string
+}
+
+// A state a commitment has entered and when
+type LifecycleStep struct {
+  State  string
+  Date   string
+}
+
+// Shows a single commitment with its full lifecycle (/commitments/{id}, ?spec= to check its spec).
+// This is the page linked to from customer emails
+func (app *Application) CommitmentHandler(w http.ResponseWriter, r *http.Request) {
+  data := CommitmentData{User: requestUser(r), ComID: strings.TrimPrefix(r.URL.Path, CommitmentPath)}
+  if data.ComID == "" || strings.Contains(data.ComID, "/") {
+    http.NotFound(w, r)
+    return
+  }
+
+  ledger, err := app.userLedger(r)
+  if err == nil {
+    data.Commitment, err = ledger.GetCommitment(r.URL.Query().Get("spec"), data.ComID)
+  }
+  if err != nil {
+    data.FailMsg = err.Error()
+    data.Failed = true
+    switch blockchain.ErrorStatus(err) {
+      case blockchain.StatusNotFound:
+        data.FailMsg = "Commitment " + data.ComID + " does not exist"
+        w.WriteHeader(http.StatusNotFound)
+      case blockchain.StatusForbidden:
+        data.FailMsg = "Only the debtor and creditor of commitment " + data.ComID + " can view it"
+        w.WriteHeader(http.StatusForbidden)
+    }
+  } else {
+    data.Lifecycle = lifecycle(data.Commitment)
+    if data.Commitment.Deadline != "" {
+      data.Remaining = formatRemaining(time.Duration(data.Commitment.TimeRemaining) * time.Second)
+    }
+  }
+  renderTemplate(w, r, "commitment.html", data)
+}
+
+// The order states are entered in, for transitions made at the same time
+var lifecycleOrder = []string{"created", "detached", "expired", "discharged", "violated", "cancelled", "released"}
+
+// The states a commitment has entered, oldest first
+func lifecycle(com *blockchain.Commitment) []LifecycleStep {
+  steps := []LifecycleStep{}
+  for _, state := range lifecycleOrder {
+    if date, ok := com.Transitions[state]; ok {
+      steps = append(steps, LifecycleStep{State: strings.Title(state), Date: date})
+    }
+  }
+  sort.SliceStable(steps, func(i, j int) bool {
+    a, _ := time.Parse(blockchain.TimeFormat, steps[i].Date)
+    b, _ := time.Parse(blockchain.TimeFormat, steps[j].Date)
+    return a.Before(b)
+  })
+  return steps
+}
+
+// Formats the time left until a deadline in days, hours and minutes
+func formatRemaining(remaining time.Duration) string {
+  if remaining < time.Minute {
+    return "less than a minute"
+  }
+  days := int(remaining / (24 * time.Hour))
+  hours := int(remaining % (24 * time.Hour) / time.Hour)
+  minutes := int(remaining % time.Hour / time.Minute)
+  if days > 0 {
+    return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+  } else if hours > 0 {
+    return fmt.Sprintf("%dh %dm", hours, minutes)
+  }
+  return fmt.Sprintf("%dm", minutes)
+}
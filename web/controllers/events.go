@@ -0,0 +1,55 @@
+package controllers
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "time"
+)
+
+// Path of the server-sent events stream of commitment transitions
+const EventsPath = "/events"
+
+// How often a comment is sent on an idle stream so proxies don't close it
+const keepAliveInterval = 30 * time.Second
+
+// Streams the commitment transitions of committed transactions as server-sent events.
+// Each event is named "transition" with the transition as JSON data; ?spec= limits them to one spec
+func (app *Application) EventsHandler(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+    return
+  }
+  spec := r.URL.Query().Get("spec")
+
+  transitions, unsubscribe := app.Ledger.SubscribeTransitions()
+  defer unsubscribe()
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("Connection", "keep-alive")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+
+  keepAlive := time.NewTicker(keepAliveInterval)
+  defer keepAlive.Stop()
+  for {
+    select {
+      case transition, ok := <-transitions:
+        if !ok {
+          return
+        }
+        if spec != "" && transition.Spec != spec {
+          continue
+        }
+        data, _ := json.Marshal(transition)
+        fmt.Fprintf(w, "id: %s\nevent: transition\ndata: %s\n\n", transition.TxID, data)
+      case <-keepAlive.C:
+        fmt.Fprint(w, ": keep-alive\n\n")
+      case <-r.Context().Done():
+        return
+    }
+    flusher.Flush()
+  }
+}
@@ -14,10 +14,6 @@ import (
   q "github.com/scc300/scc300-network/chaincode/quark"
 )
 
-const (
-  TimeFormat = "Mon Jan _2 15:04:05 2006"
-)
-
 // Stores user interface data
 type Data struct {
   SpecName        string
@@ -25,19 +21,76 @@ type Data struct {
   SpecSource      template.HTML
   Response        bool
   Coms            []blockchain.Commitment
+  ComStates       []string      // ComStates - the state of each commitment listed
+  StateCounts     []StateCount  // StateCounts - the number of commitments in each state, when listing all states
   ParsedSpec      *q.Spec
   NumComs         int
   Failed          bool
   FailMsg         string
   CompilationMsg  string
   CompilationFail bool
+  CompilationLines []SourceLine
+  SpecUpgrade     *SpecUpgrade
+  Filter          FilterForm  // Filter - the filter bar of the query form, as submitted
+  FirstPage       string  // FirstPage - link to the first page of the listed commitments (unset on the first page)
+  NextPage        string  // NextPage - link to the next page of the listed commitments (unset on the last page)
+  HistoryComID    string
+  History         []blockchain.HistoryEntry
+  User            *User
+  CSRFToken       string  // CSRFToken - anti-forgery token of the user's session, sent back by the forms that change state
+}
+
+// The number of commitments of a spec in a state
+type StateCount struct {
+  State  string
+  Count  int
+}
+
+// The filter bar of the query form. Dates are days (e.g. 2019-03-01) and args a comma separated
+// list of argument predicates (e.g. item=Chair, price>20)
+type FilterForm struct {
+  Debtor       string
+  Creditor     string
+  CreatedFrom  string
+  CreatedTo    string
+  Args         string
+}
+
+// A line of uploaded spec source with the compilation errors found on it
+type SourceLine struct {
+  Number  int
+  Text    string
+  Errors  []q.Diagnostic
+}
+
+// An uploaded spec with the same name as a registered one, shown for review before publishing it as a new version
+type SpecUpgrade struct {
+  Name      string
+  Previous  int         // Previous - the latest registered version
+  Version   int         // Version - the version it will be published as
+  Source    string
+  Diff      []DiffLine  // Diff - changes from the previous version
+}
+
+// A line of a diff between two versions of a spec: Op is "+" (added), "-" (removed) or " " (unchanged)
+type DiffLine struct {
+  Op    string
+  Text  string
 }
 
-// Reference to blockchain package
+// The ledger the applications run against (a Fabric network or the in-memory ledger)
 type Application struct {
-  Fabric *blockchain.FabricSetup
+  Ledger    blockchain.Ledger
+  Users     map[string]*User  // Users - accounts that can sign in, keyed by username
+  sessions  sessionStore
 }
 
+// Number of commitments listed per page
+const CommitmentsPageSize = 20
+
+// Format of the dates of the filter bar (as sent by date inputs)
+const DayFormat = "2006-01-02"
+
 // Syntax highlighting for quark language
 var replacer = strings.NewReplacer(
   "\n", "<br>",
@@ -52,16 +105,64 @@ var replacer = strings.NewReplacer(
   `<span style="color:blue;">detach</span>`,
   "discharge",
   `<span style="color:blue;">discharge</span>`,
+  "where",
+  `<span style="color:blue;">where</span>`,
   "deadline",
   `<span style="font-style:italic;">deadline</span>`,
 )
 
 // Main handler for both merchants and customers to perform operations
 func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.Request) {
-  fab := app.Fabric
+  data.User = requestUser(r)
+  data.CSRFToken = app.csrfToken(r)
+  ledger, err := app.userLedger(r)
+  if err != nil {
+    data.FailMsg = err.Error()
+    data.Failed = true
+    return
+  }
 
-  // Get spec file upload
-  if r.Method == "POST" {
+  // Cancel, release, delegate or assign an existing commitment, only from a form of this application
+  if operation := r.FormValue("commitment-operation"); operation != "" {
+    if r.Method != "POST" {
+      err = fmt.Errorf("Commitment operations must be submitted with POST")
+    } else if !app.checkCSRF(r) {
+      err = fmt.Errorf("Invalid or missing form token, reload the page and try again")
+    } else {
+      err = operateOnCommitment(ledger, operation, r.FormValue("comID"), r.FormValue("party"))
+    }
+    if err != nil {
+      data.FailMsg = err.Error()
+      data.Failed = true
+    }
+    return
+  }
+
+  // Events and specs are submitted with POST forms of this application too
+  submitsEvent := r.FormValue("submitted-data") == "true" || r.FormValue("submitted-commitment") == "true"
+  if submitsEvent && r.Method != "POST" {
+    data.FailMsg = "Events must be submitted with POST"
+    data.Failed = true
+    return
+  } else if r.Method == "POST" && !app.checkCSRF(r) {
+    data.FailMsg = "Invalid or missing form token, reload the page and try again"
+    data.Failed = true
+    return
+  }
+  uploadsSpec := r.Method == "POST" && !submitsEvent
+
+  // Get spec file upload (merchants only)
+  if uploadsSpec && data.User.Role != RoleMerchant {
+    data.FailMsg = "Only merchants can upload commitment specifications"
+    data.Failed = true
+  } else if uploadsSpec && r.FormValue("publish-version") == "true" {
+    // Publish a reviewed upload as a new version of its spec
+    _, err = ledger.InvokeUpgradeSpec(r.FormValue("source"))
+    if err != nil {
+      data.FailMsg = err.Error()
+      data.Failed = true
+    }
+  } else if uploadsSpec {
     file, _, err := r.FormFile("uploadfile")
     if err != nil {
       fmt.Println(err)
@@ -73,13 +174,32 @@ func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.R
     defer file.Close()
 
     // Compile spec to check syntax
-    _, er := q.Parse(specContents)
-    if er != nil {
-      data.CompilationMsg = er.Error()
+    parsed, diags := q.Parse(specContents)
+    if len(diags) > 0 {
+      data.CompilationMsg = fmt.Sprintf("%d error(s) found", len(diags))
+      data.CompilationLines = annotateSource(specContents, diags)
       data.CompilationFail = true
+    } else if existing, er := ledger.GetSpec(parsed.Constraint.Name); er == nil && existing.Source != "" {
+      // Already registered: show the changes, to be published as a new version
+      previous := existing.Version
+      if previous < 1 {
+        previous = 1
+      }
+      if existing.Source == specContents {
+        data.FailMsg = fmt.Sprintf("Spec %s is unchanged from version %d", existing.Name, previous)
+        data.Failed = true
+      } else {
+        data.SpecUpgrade = &SpecUpgrade{
+          Name:     existing.Name,
+          Previous: previous,
+          Version:  previous + 1,
+          Source:   specContents,
+          Diff:     diffLines(existing.Source, specContents),
+        }
+      }
     } else {
       // Upload new spec to blockchain
-      _, err = fab.InvokeInitSpec(specContents)
+      _, err = ledger.InvokeInitSpec(specContents)
       if err != nil {
         data.FailMsg = err.Error()
         data.Failed = true
@@ -92,18 +212,64 @@ func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.R
   if r.FormValue("query-commitments") == "true" {
     // Get user input
     data.SpecName = r.FormValue("comname")
-    comState := r.Form["commitmentState"][0]
+    comState := strings.ToLower(r.Form["commitmentState"][0])
 
     var commitments []blockchain.Commitment
+    var comStates []string
     
-    spec, er := fab.GetSpec(data.SpecName)
+    spec, er := ledger.GetSpec(data.SpecName)
+    var filter blockchain.CommitmentFilter
+    if er == nil {
+      data.Filter = FilterForm{
+        Debtor: strings.TrimSpace(r.FormValue("debtor")),
+        Creditor: strings.TrimSpace(r.FormValue("creditor")),
+        CreatedFrom: r.FormValue("created-from"),
+        CreatedTo: r.FormValue("created-to"),
+        Args: r.FormValue("args"),
+      }
+      filter, er = data.Filter.commitmentFilter()
+    }
 
     if er != nil {
       data.FailMsg = er.Error()
       data.Failed = true
     } else {
-      // Obtain commitments based on state (e.g. created, detached, expired, discharged, violated)
-      commitments, er = fab.GetCommitments(data.SpecName, comState)
+      // Obtain a page of commitments based on state (e.g. created, detached, expired, discharged, violated, cancelled, released),
+      // or of every commitment labelled with its current state (conditional, active, expired, discharged, violated, cancelled, released)
+      bookmark := r.FormValue("bookmark")
+      var page *blockchain.CommitmentPage
+      if comState == "all" {
+        page, er = ledger.GetFilteredCommitmentsPage(data.SpecName, "", filter, time.Time{}, CommitmentsPageSize, bookmark)
+      } else if filter.IsZero() {
+        page, er = ledger.GetCommitmentsPage(data.SpecName, comState, time.Time{}, CommitmentsPageSize, bookmark)
+      } else {
+        page, er = ledger.GetFilteredCommitmentsPage(data.SpecName, comState, filter, time.Time{}, CommitmentsPageSize, bookmark)
+      }
+      if comState == "all" && filter.IsZero() && er == nil {
+        // Count every commitment of the spec in each current state, not just those on this page
+        var stats *blockchain.CommitmentStats
+        if stats, er = ledger.GetCommitmentStats(data.SpecName, "", time.Time{}); er == nil {
+          for _, state := range blockchain.CurrentStates {
+            data.StateCounts = append(data.StateCounts, StateCount{State: strings.Title(state), Count: stats.Counts[state]})
+          }
+        }
+      }
+      if er == nil {
+        commitments = page.Commitments
+        for _, com := range commitments {
+          if comState == "all" {
+            comStates = append(comStates, strings.Title(currentState(com.State)))
+          } else {
+            comStates = append(comStates, strings.Title(comState))
+          }
+        }
+        if bookmark != "" {
+          data.FirstPage = pageLink(r, "")
+        }
+        if page.Bookmark != "" {
+          data.NextPage = pageLink(r, page.Bookmark)
+        }
+      }
       if er != nil {
         data.FailMsg = er.Error()
         data.Failed = true
@@ -121,11 +287,28 @@ func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.R
     // Prepare user interface output data
     if (!data.Failed) {
       data.ComState = strings.Title(comState)
-      data.SpecSource = template.HTML(replacer.Replace(spec.Source))
+      data.SpecSource = template.HTML(replacer.Replace(template.HTMLEscapeString(spec.Source)))
       data.Response = true
       data.Coms = commitments
+      data.ComStates = comStates
       data.NumComs = len(commitments)
     }
+
+    // Obtain the audit trail of a commitment when requested
+    if comID := r.FormValue("history"); comID != "" && !data.Failed {
+      history, er := ledger.GetCommitmentHistory(comID)
+      if blockchain.ErrorStatus(er) == blockchain.StatusForbidden {
+        data.FailMsg = "Only the debtor and creditor of commitment " + comID + " can view its history"
+        data.Failed = true
+        w.WriteHeader(http.StatusForbidden)
+      } else if er != nil {
+        data.FailMsg = er.Error()
+        data.Failed = true
+      } else {
+        data.HistoryComID = comID
+        data.History = history
+      }
+    }
   } else if r.FormValue("submitted-data") == "true" {
     // Upload data for a commitment spec event (detach and discharge only)
     r.ParseForm()
@@ -133,16 +316,16 @@ func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.R
     dataMap := map[string]string{
       "docType": r.FormValue("docType"),
       "comID": r.FormValue("comID"),
-      "date": time.Now().Format(TimeFormat),
     }
-    for key, values := range r.Form {
+    for key, values := range r.PostForm {
       dataMap[key] = values[0]
     }
     delete(dataMap, "submitted-data");
+    delete(dataMap, csrfField)
 
     // Marshal to JSON and add to blockchain database
     jsonMap, _ := json.Marshal(dataMap)
-    _, err := fab.InvokeInitCommitmentData([]string{string(jsonMap)})
+    _, err := ledger.InvokeInitCommitmentData([]string{string(jsonMap)})
     if (err != nil) {
       data.FailMsg = err.Error()
       data.Failed = true
@@ -154,18 +337,18 @@ func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.R
     dataMap := map[string]string{
       "docType": r.FormValue("event"),
       "comID": uuid.NewV4().String(),
-      "date": time.Now().Format(TimeFormat),
       "debtor": r.FormValue("debtor"),
       "creditor": r.FormValue("creditor"),
     }
-    for key, values := range r.Form {
+    for key, values := range r.PostForm {
       dataMap[key] = values[0]
     }
     delete(dataMap, "submitted-commitment");
+    delete(dataMap, csrfField)
 
     // Marshal to JSON and add to blockchain database
     jsonMap, _ := json.Marshal(dataMap)
-    _, err := fab.InvokeInitCommitmentData([]string{string(jsonMap)})
+    _, err := ledger.InvokeInitCommitmentData([]string{string(jsonMap)})
     if (err != nil) {
       data.FailMsg = err.Error()
       data.Failed = true
@@ -173,4 +356,122 @@ func (app *Application) MainHandler(data *Data, w http.ResponseWriter, r *http.R
   }
 }
 
+// Cancels, releases, delegates or assigns a commitment (party is the new debtor or creditor)
+func operateOnCommitment(ledger blockchain.Ledger, operation string, comID string, party string) error {
+  var err error
+  switch operation {
+    case "cancel":
+      _, err = ledger.InvokeCancelCommitment(comID)
+    case "release":
+      _, err = ledger.InvokeReleaseCommitment(comID)
+    case "delegate":
+      _, err = ledger.InvokeDelegateCommitment(comID, party)
+    case "assign":
+      _, err = ledger.InvokeAssignCommitment(comID, party)
+    default:
+      err = fmt.Errorf("Unsupported commitment operation %q", operation)
+  }
+  return err
+}
+
+// Reads the filter bar into a commitment filter. Dates are taken as UTC days, up to the end of CreatedTo
+func (form FilterForm) commitmentFilter() (blockchain.CommitmentFilter, error) {
+  filter := blockchain.CommitmentFilter{Debtor: form.Debtor, Creditor: form.Creditor}
+  if form.CreatedFrom != "" {
+    from, err := time.Parse(DayFormat, form.CreatedFrom)
+    if err != nil {
+      return filter, fmt.Errorf("Invalid date %q, expected a date like 2019-03-01", form.CreatedFrom)
+    }
+    filter.CreatedAfter = from
+  }
+  if form.CreatedTo != "" {
+    to, err := time.Parse(DayFormat, form.CreatedTo)
+    if err != nil {
+      return filter, fmt.Errorf("Invalid date %q, expected a date like 2019-03-01", form.CreatedTo)
+    }
+    filter.CreatedBefore = to.AddDate(0, 0, 1)
+  }
+  for _, arg := range strings.Split(form.Args, ",") {
+    if arg = strings.TrimSpace(arg); arg != "" {
+      filter.Args = append(filter.Args, arg)
+    }
+  }
+  return filter, nil
+}
+
+// The state a commitment in a state is listed under when listing all states: commitments in progress
+// are conditional (created) or active (detached), see blockchain.CurrentStates
+func currentState(state string) string {
+  switch state {
+    case "created":
+      return "conditional"
+    case "detached":
+      return "active"
+  }
+  return state
+}
+
+// Link to a page of the current listing, starting after the bookmark ("" for the first page)
+func pageLink(r *http.Request, bookmark string) string {
+  query := r.URL.Query()
+  query.Del("history")
+  if bookmark == "" {
+    query.Del("bookmark")
+  } else {
+    query.Set("bookmark", bookmark)
+  }
+  return "?" + query.Encode()
+}
 
+// Compares two versions of a spec source line by line (longest common subsequence)
+func diffLines(oldSource string, newSource string) []DiffLine {
+  a, b := strings.Split(oldSource, "\n"), strings.Split(newSource, "\n")
+
+  // common[i][j] - length of the longest common subsequence of a[i:] and b[j:]
+  common := make([][]int, len(a) + 1)
+  for i := range common {
+    common[i] = make([]int, len(b) + 1)
+  }
+  for i := len(a) - 1; i >= 0; i-- {
+    for j := len(b) - 1; j >= 0; j-- {
+      if a[i] == b[j] {
+        common[i][j] = common[i+1][j+1] + 1
+      } else if common[i+1][j] >= common[i][j+1] {
+        common[i][j] = common[i+1][j]
+      } else {
+        common[i][j] = common[i][j+1]
+      }
+    }
+  }
+
+  diff := []DiffLine{}
+  i, j := 0, 0
+  for i < len(a) || j < len(b) {
+    switch {
+      case i < len(a) && j < len(b) && a[i] == b[j]:
+        diff = append(diff, DiffLine{Op: " ", Text: a[i]})
+        i, j = i + 1, j + 1
+      case i < len(a) && (j == len(b) || common[i+1][j] >= common[i][j+1]):
+        diff = append(diff, DiffLine{Op: "-", Text: a[i]})
+        i++
+      default:
+        diff = append(diff, DiffLine{Op: "+", Text: b[j]})
+        j++
+    }
+  }
+  return diff
+}
+
+// Splits spec source into lines, attaching each compilation error to the line it was found on
+func annotateSource(source string, diags q.Diagnostics) []SourceLine {
+  lines := []SourceLine{}
+  for i, text := range strings.Split(source, "\n") {
+    lines = append(lines, SourceLine{Number: i + 1, Text: strings.Replace(text, "\t", " ", -1)})
+  }
+  for _, diag := range diags {
+    if diag.Line >= 1 && diag.Line <= len(lines) {
+      lines[diag.Line-1].Errors = append(lines[diag.Line-1].Errors, diag)
+    }
+  }
+  return lines
+}
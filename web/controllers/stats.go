@@ -0,0 +1,145 @@
+package controllers
+
+import (
+  "fmt"
+  "net/http"
+  "sort"
+  "strings"
+  "time"
+
+  "github.com/scc300/scc300-network/blockchain"
+)
+
+// Path of the commitment stats dashboard, /stats?spec=&groupBy=
+const StatsPath = "/stats"
+
+// Agents with a reputation below this are flagged as unreliable
+const UnreliableReputation = 0.5
+
+// Stores stats dashboard data
+type StatsData struct {
+  User                *User
+  SpecName            string
+  GroupBy             string     // GroupBy - the event argument commitments are also grouped by (e.g. Delivery.courier)
+  Total               int
+  States              []StateStat
+  AvgTimeToDetach     string
+  AvgTimeToDischarge  string
+  Reputation          string
+  Rankings            []Ranking  // Rankings - the values of the GroupBy argument (if any), debtors and creditors
+  Response            bool
+  Failed              bool
+  FailMsg             string
+}
+
+// The number and percentage of commitments in a current state
+type StateStat struct {
+  State    string
+  Count    int
+  Percent  string
+}
+
+// Agents (or values of an event argument) ranked by reputation
+type Ranking struct {
+  Title      string
+  Creditors  bool  // Creditors - whether the agents are creditors, rated on detaching their commitments
+  Agents     []AgentStat
+}
+
+// The commitments of an agent (or event argument value) and its reputation
+type AgentStat struct {
+  Name                string
+  Total               int
+  Detached            int
+  Discharged          int
+  Violated            int
+  Expired             int
+  AvgTimeToDischarge  string
+  Reputation          string  // Reputation - percentage of commitments discharged rather than violated, or detached rather than expired for creditors ("-" if neither)
+  Unreliable          bool    // Unreliable - whether the reputation is below UnreliableReputation
+  reputation          *float64
+}
+
+// Shows the stats of the commitments of a spec with the reputation of each agent (merchants only)
+func (app *Application) StatsHandler(w http.ResponseWriter, r *http.Request) {
+  data := StatsData{
+    User: requestUser(r),
+    SpecName: strings.TrimSpace(r.FormValue("spec")),
+    GroupBy: strings.TrimSpace(r.FormValue("groupBy")),
+  }
+
+  if data.SpecName != "" {
+    ledger, err := app.userLedger(r)
+    var stats *blockchain.CommitmentStats
+    if err == nil {
+      stats, err = ledger.GetCommitmentStats(data.SpecName, data.GroupBy, time.Time{})
+    }
+    if err != nil {
+      data.FailMsg = err.Error()
+      data.Failed = true
+    } else {
+      data.Response = true
+      data.Total = stats.Total
+      for _, state := range blockchain.CurrentStates {
+        data.States = append(data.States, StateStat{State: strings.Title(state), Count: stats.Counts[state], Percent: fmt.Sprintf("%.1f", stats.Percentages[state])})
+      }
+      data.AvgTimeToDetach = formatAverage(stats.AvgTimeToDetach)
+      data.AvgTimeToDischarge = formatAverage(stats.AvgTimeToDischarge)
+      data.Reputation = formatReputation(stats.Reputation)
+      if data.GroupBy != "" {
+        data.Rankings = append(data.Rankings, Ranking{Title: "By " + data.GroupBy, Agents: rankAgents(stats.Groups)})
+      }
+      data.Rankings = append(data.Rankings, Ranking{Title: "Debtors", Agents: rankAgents(stats.Debtors)})
+      data.Rankings = append(data.Rankings, Ranking{Title: "Creditors", Creditors: true, Agents: rankAgents(stats.Creditors)})
+    }
+  }
+  renderTemplate(w, r, "stats.html", data)
+}
+
+// Ranks agents by reputation (agents without one last), then by number of commitments
+func rankAgents(agents map[string]*blockchain.Stats) []AgentStat {
+  ranked := []AgentStat{}
+  for name, stats := range agents {
+    agent := AgentStat{
+      Name: name,
+      Total: stats.Total,
+      Detached: stats.Detached,
+      Discharged: stats.Counts["discharged"],
+      Violated: stats.Counts["violated"],
+      Expired: stats.Counts["expired"],
+      AvgTimeToDischarge: formatAverage(stats.AvgTimeToDischarge),
+      Reputation: formatReputation(stats.Reputation),
+      reputation: stats.Reputation,
+    }
+    agent.Unreliable = stats.Reputation != nil && *stats.Reputation < UnreliableReputation
+    ranked = append(ranked, agent)
+  }
+  sort.Slice(ranked, func(i, j int) bool {
+    a, b := ranked[i], ranked[j]
+    if (a.reputation == nil) != (b.reputation == nil) {
+      return b.reputation == nil
+    } else if a.reputation != nil && *a.reputation != *b.reputation {
+      return *a.reputation > *b.reputation
+    } else if a.Total != b.Total {
+      return a.Total > b.Total
+    }
+    return a.Name < b.Name
+  })
+  return ranked
+}
+
+// Formats an average time in seconds ("-" if there was nothing to average)
+func formatAverage(seconds int64) string {
+  if seconds <= 0 {
+    return "-"
+  }
+  return formatRemaining(time.Duration(seconds) * time.Second)
+}
+
+// Formats a reputation as a percentage ("-" if there is none yet)
+func formatReputation(reputation *float64) string {
+  if reputation == nil {
+    return "-"
+  }
+  return fmt.Sprintf("%.0f%%", *reputation * 100)
+}
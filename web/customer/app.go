@@ -8,6 +8,11 @@ import (
 // Creates a new web server for the customer application
 func InitServer(app *controllers.Application) (*http.ServeMux) {
   server := http.NewServeMux()
-  server.HandleFunc("/", app.CustomerHandler)
+  server.HandleFunc("/", app.Authenticate(app.CustomerHandler, controllers.RoleCustomer))
+  server.HandleFunc("/login", app.LoginHandler)
+  server.HandleFunc("/logout", app.LogoutHandler)
+  server.HandleFunc(controllers.CommitmentPath, app.Authenticate(app.CommitmentHandler, controllers.RoleMerchant, controllers.RoleCustomer))
+  server.HandleFunc(controllers.APIPrefix, app.Authenticate(app.APIHandler, controllers.RoleMerchant, controllers.RoleCustomer))
+  server.HandleFunc(controllers.EventsPath, app.Authenticate(app.EventsHandler, controllers.RoleMerchant, controllers.RoleCustomer))
   return server
 }
\ No newline at end of file
@@ -8,6 +8,12 @@ import (
 // Creates a new web server for the merchant application
 func InitServer(app *controllers.Application) (*http.ServeMux) {
   server := http.NewServeMux()
-  server.HandleFunc("/", app.MerchantHandler)
+  server.HandleFunc("/", app.Authenticate(app.MerchantHandler, controllers.RoleMerchant))
+  server.HandleFunc("/login", app.LoginHandler)
+  server.HandleFunc("/logout", app.LogoutHandler)
+  server.HandleFunc(controllers.StatsPath, app.Authenticate(app.StatsHandler, controllers.RoleMerchant))
+  server.HandleFunc(controllers.CommitmentPath, app.Authenticate(app.CommitmentHandler, controllers.RoleMerchant, controllers.RoleCustomer))
+  server.HandleFunc(controllers.APIPrefix, app.Authenticate(app.APIHandler, controllers.RoleMerchant, controllers.RoleCustomer))
+  server.HandleFunc(controllers.EventsPath, app.Authenticate(app.EventsHandler, controllers.RoleMerchant, controllers.RoleCustomer))
   return server
 }
\ No newline at end of file